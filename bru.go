@@ -20,23 +20,31 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
 )
 
 //	properties of every chip
 type chip struct {
-	name     string   // chip name
-	args     string   // inputs taken by the chip
-	numOuts  int      // number of outputs taken by the chip
-	numIns   int      // number of inputs taken by the chip
-	commands string   // instructions on how the chip works
-	outputs  []string // list of outputs obtained after chip has been evaluated
-	simulate bool     // simulate or not ?
-	clocked  bool     // clocked or not
+	name     string            // chip name
+	args     string            // inputs taken by the chip
+	numOuts  int               // number of outputs taken by the chip
+	numIns   int               // number of inputs taken by the chip
+	commands string            // instructions on how the chip works
+	outputs  []string          // list of outputs obtained after chip has been evaluated
+	simulate bool              // simulate or not ?
+	clocked  bool              // clocked or not
+	loops    [][2]string       // names of looped back (input, output) pairs
+	delay    int               // propagation delay of the chip as a whole, if any
+	initAll  string            // value all wires of the chip start with, if given
+	init     map[string]string // values particular wires of the chip start with
 }
 
 var bruData string              // contents of hdl file.
@@ -51,16 +59,27 @@ var oArgBitsAll []string        // Clean this mess !
 var oBufDec string              // multi bit outputs of simulation chip ('s declaration)
 var clkOBufDec string           // multi bit outputs of simulation chip ('s declaration)
 var chipsInFile []string        // names of chips in the hdl file
+var chipFiles map[string]string // hdl file every chip was read from
 var sim bool                    // I have forgotten what this variable does
 var numSim int                  // number of chips registered for simulation
 var globalClocked bool          // is the sim circuit clocked?
 var outFileName string          // name of file to store all the outputs in
-var writeblank bool             // on error, write blank -> true
 var loopCommand string          // contains the code to be added if any output is connected as an input
+var chips []chip                // every chip in the hdl file, including loaded ones
+var traceNets bool              // print every change of a net during timed simulation
+var findHazards bool            // report glitches found during timed simulation
+var dumpFile string             // file to write the contents of memories to after simulating
+var topChip string              // chip to simulate, if picked on the command line
+
+//	messages is where errors and warnings are printed
+var messages io.Writer = os.Stdout
 
 //	stores an intermediate mostly-go code. Does not contain the runtime/ main function.
 //	it is initialized with the 3 basic gates available to us- and, or and not.
-var goEquivOutput string = `
+var goEquivOutput string = goPrelude
+
+//	the code every generated program starts with
+const goPrelude string = `
 var outputs string
 
 func printArrays(arr []string) string {
@@ -117,31 +136,59 @@ func or(a, b string) string {
 `
 
 //	loadFile loads a file, ie. returns the context of a file as a string.
-func loadFile(filename string) string {
-	var content string = ""
+func loadFile(filename string) (string, error) {
 	file, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Println(err)
+		return "", &bruError{fileError, err}
 	}
-	for _, v := range file {
-		content += string(v)
-	}
-	return content
+	return string(file), nil
 }
 
-//	writeToFile writes a string to a file.
+//	writeToFile writes a string to a file. The string is written to a
+//	temporary file first, which then replaces the file, so that a file is
+//	never left half written. A file that is replaced keeps its permissions.
 func writeToFile(filename string, data string) error {
-	file, err := os.Create(filename)
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	file, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
 	if err != nil {
-		return err
+		return writeError(filename, err)
 	}
-	defer file.Close()
+	defer os.Remove(file.Name())
 
 	_, err = io.WriteString(file, data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filename)
+	}
 	if err != nil {
-		return err
+		return writeError(filename, err)
 	}
-	return file.Sync()
+	return nil
+}
+
+//	writeError returns the error for a file that could not be written. The
+//	temporary file writeToFile uses is left out of it, naming the file being
+//	written instead.
+func writeError(filename string, err error) error {
+	var path *os.PathError
+	var link *os.LinkError
+	if errors.As(err, &path) {
+		err = path.Err
+	} else if errors.As(err, &link) {
+		err = link.Err
+	}
+	return &bruError{fileError, &os.PathError{Op: "write", Path: filename, Err: err}}
 }
 
 //	returnLines splits a string at the newline character, ie. it splits
@@ -159,19 +206,34 @@ func returnLines(str string) []string {
 //	and calls the corresponding function that deals with a line that
 //	contains the keyword found. (this function delegates actions to
 //	other functions [in most cases] )
-func parseLines(lines []string) chip {
+func parseLines(lines []string) (chip, error) {
 	var newChip chip
+	var header hdl.Header
 	for k, v := range lines {
 		if strings.HasPrefix(v, "*") {
 			//	line contains declaration of chip name
 			newChip.name = strings.TrimSpace(v[1:])
+			header.Name = newChip.name
+		} else if strings.HasPrefix(v, "INIT") || strings.HasPrefix(v, "DELAY") || strings.TrimSpace(v) == "CLK" {
+			//	line contains the starting values of the chip's wires,
+			//	the propagation delay of the chip, used when it is
+			//	simulated with timing, or marks the chip as clocked
+			if err := header.Read(v); err != nil {
+				return newChip, &bruError{parseError, err}
+			}
 		} else if strings.HasPrefix(v, "IN") {
 			//	line contains declaration of chip inputs
-			newChip.args = strings.TrimSpace(v[3:])
+			if err := header.Read(v); err != nil {
+				return newChip, &bruError{parseError, err}
+			}
+			newChip.args = strings.Join(strings.Fields(v[2:]), " ")
 			newChip.numIns = strings.Count(newChip.args, " ") + 1
 		} else if strings.HasPrefix(v, "OUT") {
 			//	line contains declaration of chip outputs
-			newChip.outputs = strings.Split(v[4:], " ")
+			if err := header.Read(v); err != nil {
+				return newChip, &bruError{parseError, err}
+			}
+			newChip.outputs = strings.Fields(v[3:])
 			newChip.numOuts = len(newChip.outputs)
 		} else if strings.TrimSpace(v) == "CON" {
 			//	line indicates that the description for the chip's
@@ -188,23 +250,30 @@ func parseLines(lines []string) chip {
 			break
 		} else if strings.TrimSpace(v) == "SIM" {
 			//	line indicates that the current chip being evaluated
-			//	is also scheduled to be simumlated.
-			if numSim == 0 {
-				numSim++
-			} else {
-				fmt.Println("ERROR: More than one chip scheduled for simulation.")
-				// WRITEBLANK = true   -->   not really required here
-				os.Exit(2)
+			//	is also scheduled to be simumlated, unless the chip to
+			//	simulate was picked on the command line.
+			if topChip == "" {
+				scheduleSim(&newChip)
 			}
-			newChip.simulate = true
-			mainFuncCode += "    RUN_FUNC: [" + newChip.name + "]\n"
-			sim = true
-		} else if strings.TrimSpace(v) == "CLK" {
-			globalClocked = true
-			newChip.clocked = true
 		}
 	}
-	return newChip
+	newChip.clocked, newChip.delay, newChip.loops = header.Clocked, header.Delay, header.Loops
+	newChip.initAll, newChip.init = header.InitAll, header.Init
+	if topChip != "" && newChip.name == topChip {
+		scheduleSim(&newChip)
+	}
+	if newChip.simulate && newChip.clocked {
+		globalClocked = true
+	}
+	return newChip, nil
+}
+
+//	scheduleSim marks a chip as the one to be simulated
+func scheduleSim(c *chip) {
+	numSim++
+	c.simulate = true
+	mainFuncCode += "    RUN_FUNC: [" + c.name + "]\n"
+	sim = true
 }
 
 //	constructFuntion assembles/ generates a syntactically correct go function
@@ -235,6 +304,11 @@ func constructFunction(chip chip) string {
 		}
 	}
 	for _, v := range inps {
+		if strings.Contains(v, "|") {
+			//	outputs are only looped back when the chip is simulated,
+			//	otherwise this is an ordinary input
+			v = v[strings.Index(v, "(")+1 : strings.Index(v, "|")]
+		}
 		if strings.Index(v, "[") == -1 {
 			inArgsBits += v + " "
 		} else {
@@ -335,21 +409,35 @@ v = "X"
 //	preproc is a preprocessor that identifies the "load" block in the file and replaces
 //	the block with the contents of the hdl files whose names are listed within this load
 //	block. If the file after one replacement still contains a "load" block, it calls
-//	itself recursively till there are no more "load" blocks remaining
-func preproc() {
+//	itself recursively till there are no more "load" blocks remaining. The files are
+//	looked for in the folder of filename, the file being read.
+func preproc(filename string) error {
 	if strings.Index(bruData, "[") == -1 {
-		return
+		return nil
 	}
 
 	if strings.Index(bruData, "LOAD") == -1 {
-		return
+		return nil
+	}
+	if strings.Index(bruData, "]") < strings.Index(bruData, "[") {
+		return newError(parseError, "LOAD block is never closed with ']'")
 	}
 
 	loads := strings.Split(strings.TrimSpace(bruData[strings.Index(bruData, "[")+1:strings.Index(bruData, "]")]), "\n")
 	bruData = bruData[strings.Index(bruData, "]")+1:]
 
 	for _, v := range loads {
-		tempFile := loadFile(v)
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		v = hdl.RelativeTo(filename, strings.TrimSpace(v))
+		tempFile, err := loadFile(v)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(tempFile, "*") || !strings.Contains(tempFile, "END") {
+			return newError(parseError, "no chips found in loaded file %s", v)
+		}
 		var chipInTF string
 		flag := false
 		for {
@@ -357,13 +445,14 @@ func preproc() {
 			tempFile = tempFile[strings.Index(tempFile, "END")+3:]
 			for _, v := range chipsInFile {
 				if retNames(chipInTF)[0] == v {
-					fmt.Println("WARNING : preventing double loading of --> " + retNames(chipInTF)[0])
+					fmt.Fprintln(messages, "WARNING : preventing double loading of --> "+retNames(chipInTF)[0])
 					flag = true
 					break
 				}
 			}
 			if flag == false {
 				bruData = chipInTF + "\n" + bruData
+				chipFiles[retNames(chipInTF)[0]] = v
 			} else {
 				flag = false
 			}
@@ -372,25 +461,35 @@ func preproc() {
 			}
 		}
 		if strings.Contains(bruData, "LOAD") {
-			preproc()
+			if err := preproc(filename); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
 //	makeChip calls other functions to interpret the contents of the hdl file, and
 //	for each chip declared in the hdl file, it generates a chip object and also
 //	adds the go equivalent code for that chip to the goEquivOutput variable.
-func makeChip() {
-	var chips []chip
+func makeChip() error {
 	var temp string
 	numChips := strings.Count(bruData, "*")
 
 	for i := 0; i < numChips; i++ {
-		temp = bruData[strings.Index(bruData, "*") : strings.Index(bruData, "END")+3]
-		bruData = bruData[strings.Index(bruData, "END")+3:]
+		start, end := strings.Index(bruData, "*"), strings.Index(bruData, "END")
+		if end < start {
+			return newError(parseError, "chip %s has no END", retNames(bruData[start:])[0])
+		}
+		temp = bruData[start : end+3]
+		bruData = bruData[end+3:]
 		lines := returnLines(temp)
-		chips = append(chips, parseLines(lines))
-		if strings.Contains(mainFuncCode, chips[i].name) {
+		c, err := parseLines(lines)
+		if err != nil {
+			return err
+		}
+		chips = append(chips, c)
+		if strings.Contains(mainFuncCode, "["+chips[i].name+"]") {
 			scNumOuts = chips[i].numOuts
 			scNumIns = chips[i].numIns
 			inps := strings.Split(chips[i].args, " ")
@@ -400,7 +499,7 @@ func makeChip() {
 					l := chips[i].args
 					in := v[strings.Index(v, "(")+1 : strings.Index(v, "|")]
 					out := v[strings.Index(v, "|")+1 : strings.Index(v, ")")]
-					loopCommand += in + " = " + out + "\n"
+					loopCommand += strings.Split(in, "[")[0] + " = " + strings.Split(out, "[")[0] + "\n"
 					v = v[strings.Index(v, "(")+1 : strings.Index(v, "|")]
 					p := "(" + in + "|" + out + ")"
 					l = l[:strings.Index(l, p)] + in + l[strings.Index(l, p)+len(p):]
@@ -417,6 +516,7 @@ func makeChip() {
 		goEquivOutput += constructFunction(chips[i])
 		goEquivOutput += "\n\n"
 	}
+	return nil
 }
 
 func prepareOutput(vars []string) string {
@@ -437,13 +537,10 @@ func prepareOutput(vars []string) string {
 //	contents to the finalGo variable. It also adds code to declare and initialize
 //	variables for the inputs and outputs to be provided and obtained to and from the
 //	chip that is being simulated.
-func interpretScript(simFunc, scriptData string) {
+func interpretScript(simFunc, scriptData string) error {
 	if globalClocked {
 		if strings.Contains(scriptData, "call") {
-			finalGo = ""
-			fmt.Println("ERROR: CLOCKED chip not compatible with \"call\" command")
-			//os.Exit(2)
-			return
+			return newError(scriptError, "CLOCKED chip not compatible with \"call\" command")
 		}
 		if sim {
 			lines := returnLines(scriptData)
@@ -453,12 +550,12 @@ func interpretScript(simFunc, scriptData string) {
 			varList, varDec := assembleVlistVdec()
 			mainFuncStuff += varDec
 			for _, v := range lines {
+				if evalOnly(v) {
+					continue
+				}
 				if strings.Contains(v, "=") {
 					if moreDurs && strings.Contains(v, "dur") {
-						fmt.Println("ERROR: 'dur' declared more than once")
-						writeblank = true
-						finalGo = ""
-						return
+						return newError(parseError, "'dur' declared more than once")
 					}
 					if strings.Contains(v, "dur") && moreDurs == false {
 						moreDurs = true
@@ -468,7 +565,9 @@ func interpretScript(simFunc, scriptData string) {
 						mainFuncStuff += "\nfor t := 0; t < dur; t++ {"
 
 						inpvars := strings.Split(varList, ",")
-						mainFuncStuff += "\nif "
+						//	the chip is always evaluated in the first cycle, the
+						//	l-copies start out equal to the inputs they follow
+						mainFuncStuff += "\nif t == 0 || "
 						for k, s := range inpvars {
 							s = strings.TrimSpace(s)
 							mainFuncStuff += "l" + s + " != " + s
@@ -528,7 +627,7 @@ func interpretScript(simFunc, scriptData string) {
 							}
 						}
 						mainFuncStuff += "\n" + loopCommand
-					} else if strings.Contains(v, "t") {
+					} else if strings.TrimSpace(v[:strings.Index(v, "=")]) == "t" {
 						if firstIF {
 							mainFuncStuff += "\nif " + strings.ReplaceAll(v, "=", "==")
 							firstIF = false
@@ -536,17 +635,11 @@ func interpretScript(simFunc, scriptData string) {
 							mainFuncStuff += " else if " + strings.ReplaceAll(v, "=", "==")
 						}
 					} else {
-						literal := v[strings.Index(v, "="):]
-						switch {
-						case strings.Contains(literal, "0"):
-							literal = strings.ReplaceAll(literal, "0", "\"0\"")
-						case strings.Contains(literal, "1"):
-							literal = strings.ReplaceAll(literal, "1", "\"1\"")
-						case strings.Contains(literal, "X"):
-							literal = strings.ReplaceAll(literal, "X", "\"X\"")
+						assign, err := goAssignment(v)
+						if err != nil {
+							return &bruError{scriptError, err}
 						}
-						v = v[:strings.Index(v, "=")]
-						mainFuncStuff += "\n" + v + literal
+						mainFuncStuff += "\n" + assign
 					}
 				} else {
 					if strings.Contains(v, "}") {
@@ -569,26 +662,15 @@ func interpretScript(simFunc, scriptData string) {
 		var varList string
 		decalred := false
 		for _, v := range lines {
-			if strings.Contains(v, "//") {
+			if strings.Contains(v, "//") || evalOnly(v) {
 				continue
 			}
 			if strings.Contains(v, "=") {
-				literal := v[strings.Index(v, "="):]
-				switch {
-				case strings.Contains(literal, "0"):
-					literal = strings.ReplaceAll(literal, "0", "\"0\"")
-				case strings.Contains(literal, "1"):
-					literal = strings.ReplaceAll(literal, "1", "\"1\"")
-				case strings.Contains(literal, "X"):
-					literal = strings.ReplaceAll(literal, "X", "\"X\"")
-				}
-				if decalred == false {
-					v = v[:strings.Index(v, "=")]
-					mainGo += v + literal + "\n"
-				} else {
-					v = v[:strings.Index(v, "=")]
-					mainGo += v + literal + "\n"
+				assign, err := goAssignment(v)
+				if err != nil {
+					return &bruError{scriptError, err}
 				}
+				mainGo += assign + "\n"
 			} else if command := strings.TrimSpace(v); command == "call" {
 				if decalred == false {
 					varList, temp = assembleVlistVdec()
@@ -604,6 +686,13 @@ func interpretScript(simFunc, scriptData string) {
 	} else {
 		finalGo = goEquivOutput[:strings.Index(goEquivOutput, "$")]
 	}
+	return nil
+}
+
+//	evalOnly reports whether a script line is one that only bru itself can
+//	run, and that is left out of the generated go code
+func evalOnly(line string) bool {
+	return isProbe(line) || isCheck(line) || isTruthTable(line) || isVectors(line) || isRadixLine(line)
 }
 
 var outVarList string
@@ -623,7 +712,7 @@ func assembleVlistVdec() (string, string) {
 			if globalClocked {
 				clockedVarList += "l" + v
 			}
-			vals += "\"X\""
+			vals += initialValue(v)
 			if k != len(inArgsBits)-1 {
 				vals += ", "
 				varList += ", "
@@ -639,7 +728,7 @@ func assembleVlistVdec() (string, string) {
 	}
 	template :=
 		`for k := range I {
-		v = "X"
+		v = @
 	}`
 	if len(scInArgsBufs) > 0 {
 		if scInArgsBits != "" {
@@ -648,10 +737,10 @@ func assembleVlistVdec() (string, string) {
 		for k, v := range scInArgsBufs {
 			n := v[:strings.Index(v, "[")]
 			varDec += "\nvar " + n + " " + v[strings.Index(v, "["):strings.Index(v, "]")+1] + "string"
-			varDec += "\n" + strings.Replace(strings.Replace(template, "v", n+"[k]", 1), "I", n, 1)
+			varDec += "\n" + strings.Replace(strings.Replace(strings.Replace(template, "v", n+"[k]", 1), "I", n, 1), "@", initialValue(n), 1)
 			if globalClocked {
 				varDec += "\nvar l" + n + " " + v[strings.Index(v, "["):strings.Index(v, "]")+1] + "string"
-				varDec += "\n" + strings.Replace(strings.Replace(template, "v", "l"+n+"[k]", 1), "I", n, 1)
+				varDec += "\n" + strings.Replace(strings.Replace(strings.Replace(template, "v", "l"+n+"[k]", 1), "I", n, 1), "@", initialValue(n), 1)
 			}
 			varList += n
 			if k != len(scInArgsBufs)-1 {
@@ -669,7 +758,7 @@ func assembleVlistVdec() (string, string) {
 			clockedVarList += "var "
 			for l, v := range scOArgsBits {
 				vlO += v
-				vals2 += "\"X\""
+				vals2 += initialValue(v)
 				if globalClocked {
 					clockedVarList += "l" + v
 				}
@@ -697,6 +786,27 @@ func assembleVlistVdec() (string, string) {
 	return varList, varDec
 }
 
+//	initialValue returns the go expression a variable of the simulated chip is
+//	initialized with: its starting value from the chip's INIT line if it has
+//	one, and the value given with --init otherwise.
+func initialValue(name string) string {
+	v := initValue
+	for _, c := range chips {
+		if c.simulate {
+			if c.initAll != "" {
+				v = c.initAll
+			}
+			if r, ok := c.init[name]; ok {
+				v = r
+			}
+		}
+	}
+	if v == "random" {
+		return "randomBit()"
+	}
+	return "\"" + v + "\""
+}
+
 //	assembleFuncCall prepares the function call for the chip being simulated
 //	with the right number of inputs
 func assembleFuncCall(funcName string, varList string) string {
@@ -705,64 +815,72 @@ func assembleFuncCall(funcName string, varList string) string {
 	return functionCall
 }
 
-//	ui adds finishing touches to the finalGo variable and also looks at the command line arguments provided
-//	to check the mode of execution and loads the script files content if the run-mode provided is the script
-//	mode.
-func ui() {
+//	ui adds finishing touches to the finalGo variable and, if a chip is to be simulated, loads the script
+//	file's contents and translates them into the main function. finalGo is
+//	left empty if anything goes wrong.
+func ui(script string) error {
+	finalGo = ""
+	if numSim > 1 {
+		return newError(semanticError, "More than one chip scheduled for simulation.\n\tTry using 'bru run' to simulate several chips at once.")
+	}
 	if sim == true {
-		if len(os.Args) <= 3 {
-			fmt.Println("ERROR : script not found. \n\tTry using '-s scriptName' to provide a script file.")
-			finalGo = ""
-			// WRITEBLANK = true   -->   not really required here
-			return
+		if script == "" {
+			return newError(scriptError, "script not found. \n\tTry using 'bru build file.hdl scriptName' to provide a script file.")
+		}
+		if globalClocked && outFileName == "" {
+			return newError(scriptError, "Filename to store outputs not specified.\n\tTry using '--results fileName'.")
+		}
+		for _, c := range chips {
+			if strings.Contains(c.commands, "ram<") || strings.Contains(c.commands, "rom<") {
+				return newError(semanticError, "chip %s uses memories, which can only be simulated with 'bru run'.", c.name)
+			}
+		}
+		scriptData, err := loadFile(script)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(scriptData) == "" {
+			return newError(scriptError, "script file is empty.")
+		}
+		for _, v := range returnLines(scriptData) {
+			if isControl(v) {
+				return newError(scriptError, "loops, variables, procedures, conditionals and 'step' only work when simulating with 'bru run'.")
+			}
 		}
 		simFunc := goEquivOutput[strings.Index(goEquivOutput, "$")+1 : strings.LastIndex(goEquivOutput, "$")]
 		simFunc = simFunc[strings.Index(simFunc, "[")+1 : strings.Index(simFunc, "]")]
 		simFunc = strings.TrimSpace(simFunc)
-		var runMode string
-		var scriptData string
-		var indexStart int
 		finalGo = "package main\n\nimport (\n\"io\"\n\"os\""
 		if !globalClocked {
 			finalGo += "\n\"fmt\""
 		}
+		if initValue == "random" {
+			finalGo += "\n\"math/rand\""
+		}
 		finalGo += "\n)\n"
 		finalGo += goEquivOutput[:strings.Index(goEquivOutput, "$")]
+		if initValue == "random" {
+			finalGo += "\nfunc randomBit() string {\n\treturn []string{\"0\", \"1\"}[rand.Intn(2)]\n}\n"
+		}
 		finalGo += "\nfunc main() {\n"
-		if len(os.Args) > 2 {
-			indexStart = strings.Count(os.Args[2], "-")
-			runMode = os.Args[2][indexStart:]
-			switch {
-			case runMode == "i" || runMode == "stdin":
-				fmt.Println("feature not ready yet")
-			case runMode == "s" || runMode == "script":
-				scriptData = loadFile(os.Args[3])
-				if strings.TrimSpace(scriptData) == "" {
-					fmt.Println("ERROR: script file is empty.")
-					// WRITEBLANK = true   -->   not really required here
-					finalGo = ""
-					return
-					//os.Exit(1)
-				}
-				interpretScript(simFunc, scriptData)
-				finalGo += "\n}"
+		for _, v := range returnLines(scriptData) {
+			if evalOnly(v) {
+				fmt.Fprintln(messages, "WARNING : probes, checks, truth tables and test vectors only work when simulating with 'bru run'")
+				break
 			}
 		}
-		if globalClocked {
-			if len(os.Args) < 6 {
-				fmt.Println("ERROR: Filename to store outputs not specified.")
-				// WRITEBLANK = true   -->   not really required here
-				finalGo = ""
-				return
-				//os.Exit(2)
-			}
+		if err := interpretScript(simFunc, scriptData); err != nil {
+			finalGo = ""
+			return err
 		}
+		finalGo += "\n}"
 	} else if sim == false {
-		if len(os.Args) > 2 {
-			fmt.Println("WARNING : script given but nothing to simulate")
+		if script != "" {
+			fmt.Fprintln(messages, "WARNING : script given but nothing to simulate")
 		}
 		finalGo = goEquivOutput[:strings.Index(goEquivOutput, "$")]
 	}
+	return nil
 }
 
 //	retrieve names of all chips in the hdl file
@@ -780,26 +898,31 @@ func retNames(from string) []string {
 	return names
 }
 
-func main() {
-	writeblank = false
-	if len(os.Args) > 4 {
-		fileMode := os.Args[4][strings.Index(os.Args[4], "-")+1:]
-		if fileMode == "o" {
-			if len(os.Args) == 5 {
-				fmt.Println("ERROR: Filename to store outputs not specified.")
-				os.Exit(2)
-			} else {
-				outFileName = os.Args[5]
-				fmt.Println(os.Args[5])
-			}
-		}
+//	readHDL reads an hdl file, along with the files it loads, and makes the
+//	chips in it. Anything left over from reading a file before is forgotten.
+func readHDL(filename string) error {
+	chips, chipsInFile = nil, nil
+	numSim, sim, globalClocked = 0, false, false
+	mainFuncCode, goEquivOutput, loopCommand = "$\n", goPrelude, ""
+	scInArgsBits, scInArgsBufs, scOArgsBits, oArgBitsAll, oBufDec = "", nil, nil, nil, ""
+	var err error
+	if bruData, err = loadFile(filename); err != nil {
+		return err
 	}
-	bruData = loadFile(os.Args[1])
 	chipsInFile = retNames(bruData)
-	preproc()
-	makeChip()
-	mainFuncCode += "$\n"
-	goEquivOutput += mainFuncCode
-	ui()
-	writeToFile("main.go", finalGo)
+	chipFiles = map[string]string{}
+	for _, name := range chipsInFile {
+		chipFiles[name] = filename
+	}
+	if err := preproc(filename); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := makeChip(); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+func main() {
+	os.Exit(runCLI(os.Args[1:]))
 }
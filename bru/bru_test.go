@@ -0,0 +1,367 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package bru
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const adder = `
+* full_adder
+IN a b c
+OUT s co
+CON
+    t = or(and(a, not(b)), and(not(a), b))
+    s = or(and(t, not(c)), and(not(t), c))
+    co = or(and(a, b), and(t, c))
+END
+
+* add4
+SIM
+IN a[4] b[4] c
+OUT s[4] co
+CON
+    s[0], c1 = full_adder(a[0], b[0], c)
+    s[1], c2 = full_adder(a[1], b[1], c1)
+    s[2], c3 = full_adder(a[2], b[2], c2)
+    s[3], co = full_adder(a[3], b[3], c3)
+END
+`
+
+const latch = `
+* nand
+IN a b
+OUT o
+CON
+    o = not(and(a, b))
+END
+
+* srlatch
+IN s r
+OUT q qn
+CON
+    q = nand(s, qn)
+    qn = nand(r, q)
+END
+
+* ring
+INIT 0
+IN a
+OUT o
+CON
+    o = not(and(a, o))
+END
+`
+
+const toggler = `
+* tog
+CLK
+INIT q=0 n=0
+IN e (q|n)
+OUT n
+CON
+    n = or(and(e, not(q)), and(not(e), q))
+END
+
+* half
+IN a b
+OUT s c
+CON
+    s = or(and(a, not(b)), and(not(a), b))
+    c = and(a, b)
+END
+
+* count
+CLK
+INIT q=0
+IN en (q[3]|n[3])
+OUT n[3]
+CON
+    n[0], c0 = half(q[0], en)
+    n[1], c1 = half(q[1], c0)
+    n[2], c2 = half(q[2], c1)
+END
+
+* mem
+IN addr[2] d[4] w
+OUT o[4]
+CON
+    o = ram<4, 4>(addr, d, w)
+END
+`
+
+//	simulate parses src and returns a simulator for the chip top in it
+func simulate(t *testing.T, src, top string) *Simulator {
+	t.Helper()
+	d, err := ParseString(src)
+	if err != nil {
+		t.Fatalf("ParseString: %v", err)
+	}
+	c, err := d.Elaborate(top)
+	if err != nil {
+		t.Fatalf("Elaborate(%q): %v", top, err)
+	}
+	return NewSimulator(c)
+}
+
+//	set gives the wires of a simulator values, eg. "a=1 b=0x3"
+func set(t *testing.T, s *Simulator, values string) {
+	t.Helper()
+	for _, v := range strings.Fields(values) {
+		i := strings.Index(v, "=")
+		if err := s.Set(v[:i], v[i+1:]); err != nil {
+			t.Fatalf("Set(%q, %q): %v", v[:i], v[i+1:], err)
+		}
+	}
+}
+
+//	expect checks the values of the wires of a simulator, eg. "s=0b0111"
+func expect(t *testing.T, s *Simulator, values string) {
+	t.Helper()
+	for _, v := range strings.Fields(values) {
+		i := strings.Index(v, "=")
+		got, err := s.Get(v[:i])
+		if err != nil {
+			t.Fatalf("Get(%q): %v", v[:i], err)
+		}
+		if got != v[i+1:] {
+			t.Errorf("%s = %s, want %s", v[:i], got, v[i+1:])
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{"* a\nIN x\nOUT y\nCON\n    y = not(x)\n", "no END"},
+		{"* a\nIN x[0]\nOUT y\nCON\n    y = x\nEND\n", "malformed buffer size"},
+		{"* a\nIN x\nOUT y\nCON\n    y = not(x)\nEND\n* a\nIN x\nOUT y\nCON\n    y = x\nEND\n", "declared more than once"},
+		{"* a\nIN\nOUT y\nCON\n    y = x\nEND\n", "no inputs given"},
+		{"* a\nIN x\nOUT\nCON\n    y = x\nEND\n", "no outputs given"},
+		{"* a\nIN (x|y\nOUT y\nCON\n    y = x\nEND\n", "malformed looped back input"},
+		{"* a\nINIT q=2\nIN x\nOUT y\nCON\n    y = x\nEND\n", "invalid starting value"},
+		{"* a\nDELAY -1\nIN x\nOUT y\nCON\n    y = x\nEND\n", "invalid delay"},
+	}
+	for _, test := range tests {
+		_, err := ParseString(test.src)
+		if err == nil {
+			t.Errorf("ParseString(%q) gave no error", test.src)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("ParseString(%q) = %v, want an error containing %q", test.src, err, test.err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"top.hdl":       "LOAD [\n    lib/gates.hdl\n]\n\n* top\nSIM\nIN a b\nOUT o\nCON\n    o = nand(a, b)\nEND\n",
+		"lib/gates.hdl": "LOAD [\n    not.hdl\n]\n\n* nand\nIN a b\nOUT o\nCON\n    o = inv(and(a, b))\nEND\n",
+		"lib/not.hdl":   "* inv\nIN a\nOUT o\nCON\n    o = not(a)\nEND\n",
+	}
+	for name, src := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//	files are loaded from the folder of the file that loads them, not
+	//	the current one
+	d, err := Parse(filepath.Join(dir, "top.hdl"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	c, err := d.Elaborate("")
+	if err != nil {
+		t.Fatalf("Elaborate: %v", err)
+	}
+	s := NewSimulator(c)
+	set(t, s, "a=1 b=1")
+	if err := s.Eval(); err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	expect(t, s, "o=0")
+}
+
+func TestElaborateErrors(t *testing.T) {
+	tests := []struct {
+		src, top, err string
+	}{
+		{adder, "sub4", "chip sub4 not found"},
+		{"* a\nIN x\nOUT y\nCON\n    y = b(x)\nEND\n", "a", "unknown chip 'b'"},
+		{"* a\nIN x\nOUT y\nCON\n    y = a(x)\nEND\n", "a", "contains itself"},
+		{"* a\nIN x\nOUT y\nCON\n    y = not(x\nEND\n", "a", "expected"},
+		{"* a\nIN x\nOUT y\nCON\n    z = not(x)\nEND\n", "a", "never assigned"},
+	}
+	for _, test := range tests {
+		d, err := ParseString(test.src)
+		if err != nil {
+			t.Errorf("ParseString(%q): %v", test.src, err)
+			continue
+		}
+		_, err = d.Elaborate(test.top)
+		if err == nil {
+			t.Errorf("Elaborate(%q) of %q gave no error", test.top, test.src)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Elaborate(%q) of %q = %v, want an error containing %q", test.top, test.src, err, test.err)
+		}
+	}
+}
+
+func TestBuses(t *testing.T) {
+	s := simulate(t, adder, "add4")
+	tests := []struct {
+		in, out string
+	}{
+		{"a=0x3 b=0x4 c=0", "s=0b0111 co=0 s[2]=1 s[3]=0"},
+		{"a=0b1111 b=1 c=0", "s=0b0000 co=1"},
+		{"a=4'd9 b=4'hA c=1", "s=0b0100 co=1"},
+		{"a=X b=0 c=0", "s=0bXXXX co=0"},
+	}
+	for _, test := range tests {
+		set(t, s, test.in)
+		if err := s.Eval(); err != nil {
+			t.Fatalf("Eval: %v", err)
+		}
+		expect(t, s, test.out)
+	}
+	for _, v := range [][2]string{{"a", "0x1F"}, {"a", "3'b101"}, {"c", "2"}, {"s", "0"}, {"d", "0"}} {
+		if err := s.Set(v[0], v[1]); err == nil {
+			t.Errorf("Set(%q, %q) gave no error", v[0], v[1])
+		}
+	}
+	bits, err := s.Bits("a")
+	if err != nil || strings.Join(bits, "") != "XXXX" {
+		t.Errorf("Bits(\"a\") = %v, %v, want [X X X X]", bits, err)
+	}
+}
+
+func TestLatch(t *testing.T) {
+	s := simulate(t, latch, "srlatch")
+	steps := []struct {
+		in, out string
+	}{
+		{"s=0 r=1", "q=1 qn=0"},
+		{"s=1 r=1", "q=1 qn=0"},
+		{"s=1 r=0", "q=0 qn=1"},
+		{"s=1 r=1", "q=0 qn=1"},
+	}
+	for _, step := range steps {
+		set(t, s, step.in)
+		if err := s.Eval(); err != nil {
+			t.Fatalf("Eval after %s: %v", step.in, err)
+		}
+		expect(t, s, step.out)
+	}
+
+	s = simulate(t, latch, "ring")
+	set(t, s, "a=1")
+	var settle *SettleError
+	if err := s.Eval(); !errors.As(err, &settle) {
+		t.Fatalf("Eval of ring = %v, want a SettleError", err)
+	}
+	expect(t, s, "o=X")
+}
+
+func TestStep(t *testing.T) {
+	s := simulate(t, toggler, "tog")
+	want := []string{"1", "0", "1", "0"}
+	set(t, s, "e=1")
+	for k, v := range want {
+		if err := s.Step(); err != nil {
+			t.Fatalf("Step: %v", err)
+		}
+		expect(t, s, "n="+v+" q="+v)
+		if s.Cycle() != k+1 {
+			t.Errorf("Cycle() = %d, want %d", s.Cycle(), k+1)
+		}
+	}
+	set(t, s, "e=0")
+	if err := s.Step(); err != nil {
+		t.Fatalf("Step: %v", err)
+	}
+	expect(t, s, "n=0 q=0")
+
+	//	buffers are looped back a bit at a time
+	s = simulate(t, toggler, "count")
+	counts := []string{"0b000", "0b001", "0b010", "0b011", "0b100", "0b101", "0b110", "0b111"}
+	set(t, s, "en=1")
+	for k := 1; k <= 9; k++ {
+		if err := s.Step(); err != nil {
+			t.Fatalf("Step: %v", err)
+		}
+		expect(t, s, "q="+counts[k%8])
+	}
+
+	s = simulate(t, toggler, "mem")
+	if err := s.Step(); err == nil {
+		t.Error("Step of a chip that is not clocked gave no error")
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := simulate(t, toggler, "tog")
+	set(t, s, "e=1")
+	for k := 0; k < 3; k++ {
+		if err := s.Step(); err != nil {
+			t.Fatalf("Step: %v", err)
+		}
+	}
+	expect(t, s, "q=1")
+	set(t, s, "reset=1")
+	if err := s.Step(); err != nil {
+		t.Fatalf("Step: %v", err)
+	}
+	expect(t, s, "n=0 q=0")
+	set(t, s, "reset=0")
+	if err := s.Step(); err != nil {
+		t.Fatalf("Step: %v", err)
+	}
+	expect(t, s, "n=1")
+
+	s = simulate(t, toggler, "mem")
+	set(t, s, "addr=2 d=0xA w=1")
+	if err := s.Eval(); err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	set(t, s, "w=0")
+	if err := s.Eval(); err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	expect(t, s, "o=0b1010")
+	set(t, s, "reset=1")
+	if err := s.Eval(); err != nil {
+		t.Fatalf("Eval: %v", err)
+	}
+	expect(t, s, "o=0bXXXX")
+}
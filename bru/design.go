@@ -0,0 +1,221 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+//	Package bru reads circuits written in Bru's HDL and simulates them, for
+//	programs that want to use Bru without running the bru command.
+//
+//	A Design holds the chips read from HDL files. Elaborating a chip of the
+//	design flattens it, and every chip it uses, into a Circuit of primitive
+//	gates, which any number of Simulators can then simulate:
+//
+//		d, err := bru.Parse("adder.hdl")
+//		if err != nil {
+//			return err
+//		}
+//		c, err := d.Elaborate("adder")
+//		if err != nil {
+//			return err
+//		}
+//		s := bru.NewSimulator(c)
+//		s.Set("a", "0x3")
+//		s.Set("b", "0x4")
+//		s.Set("c", "0")
+//		if err := s.Eval(); err != nil {
+//			return err
+//		}
+//		sum, _ := s.Get("s") // "0b0111"
+//
+//	The package keeps no state of its own. Designs and circuits are never
+//	changed once they have been made, so they can be shared by goroutines,
+//	and every simulator has wires and memories of its own.
+package bru
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	Design is the set of chips read from one or more HDL files, including the
+//	chips those files load
+type Design struct {
+	Chips []*Chip
+}
+
+//	Chip is a chip as declared in an HDL file
+type Chip struct {
+	Name     string
+	Inputs   []Port
+	Outputs  []Port
+	Simulate bool              // marked with SIM
+	Clocked  bool              // marked with CLK
+	Delay    int               // propagation delay given with DELAY, if any
+	Loops    [][2]string       // names of looped back (input, output) pairs, written (in|out)
+	InitAll  string            // value all wires of the chip start with, if given
+	Init     map[string]string // values particular wires of the chip start with
+	File     string            // file the chip was read from
+	Line     int               // line of that file the chip starts on
+
+	body []bodyLine // the lines between CON and END
+}
+
+//	bodyLine is a line between CON and END, along with its line number
+type bodyLine struct {
+	text string
+	line int
+}
+
+//	Port is an input or output of a chip
+type Port struct {
+	Name  string
+	Width int  // number of bits
+	Bus   bool // true for buffers, eg. a[4], even if only 1 bit wide
+}
+
+//	Parse reads the chips in the given HDL files, and in the files they load
+func Parse(files ...string) (*Design, error) {
+	d := &Design{}
+	for _, f := range files {
+		if err := d.readFile(f, false); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+//	ParseString reads the chips in HDL source held in memory. Files loaded by
+//	it are looked for in the current directory.
+func ParseString(src string) (*Design, error) {
+	d := &Design{}
+	if err := d.read("", src, false); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+//	Chip returns the chip with the given name, or nil if there is none
+func (d *Design) Chip(name string) *Chip {
+	for _, c := range d.Chips {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+//	readFile reads the chips in an HDL file. Chips read from a file that was
+//	loaded by another are skipped if a chip with the same name was already
+//	read, just as the bru command does.
+func (d *Design) readFile(filename string, loaded bool) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return d.read(filename, string(content), loaded)
+}
+
+//	read reads the chips in the HDL source from the named file
+func (d *Design) read(filename, src string, loaded bool) error {
+	where := func(k int) string {
+		if filename == "" {
+			return "line " + strconv.Itoa(k+1)
+		}
+		return filename + ":" + strconv.Itoa(k+1)
+	}
+	var c *Chip
+	var h hdl.Header
+	inCon, inLoad := false, false
+	lines := strings.Split(src, "\n")
+	for k, v := range lines {
+		v = strings.TrimSpace(v)
+		switch {
+		case inLoad || strings.HasPrefix(v, "LOAD"):
+			if !inLoad {
+				v = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(v[4:]), "["))
+				inLoad = true
+			}
+			end := strings.HasSuffix(v, "]")
+			if name := strings.TrimSpace(strings.TrimSuffix(v, "]")); name != "" {
+				if err := d.readFile(hdl.RelativeTo(filename, name), true); err != nil {
+					return fmt.Errorf("%s: %v", where(k), err)
+				}
+			}
+			inLoad = !end
+		case inCon && v == "END":
+			c.declare(&h)
+			if err := d.add(c, loaded); err != nil {
+				return fmt.Errorf("%s: %v", where(c.Line-1), err)
+			}
+			c, inCon = nil, false
+		case inCon:
+			c.body = append(c.body, bodyLine{v, k + 1})
+		case v == "" || strings.HasPrefix(v, "//"):
+		case strings.HasPrefix(v, "*"):
+			if c != nil {
+				return fmt.Errorf("%s: chip %s has no CON block", where(k), c.Name)
+			}
+			c = &Chip{Name: strings.TrimSpace(v[1:]), File: filename, Line: k + 1}
+			if c.Name == "" || strings.ContainsAny(c.Name, " \t") {
+				return fmt.Errorf("%s: invalid chip name '%s'", where(k), c.Name)
+			}
+			h = hdl.Header{Name: c.Name}
+		case c == nil:
+			return fmt.Errorf("%s: '%s' outside of any chip", where(k), v)
+		default:
+			if err := h.Read(v); err != nil {
+				return fmt.Errorf("%s: %v", where(k), err)
+			}
+			inCon = v == "CON"
+		}
+	}
+	if c != nil {
+		return fmt.Errorf("%s: chip %s has no END", where(len(lines)-1), c.Name)
+	}
+	return nil
+}
+
+//	declare gives a chip what the lines before its CON block declared
+func (c *Chip) declare(h *hdl.Header) {
+	for _, p := range h.Inputs {
+		c.Inputs = append(c.Inputs, Port{Name: p.Name, Width: p.Width, Bus: p.Bus})
+	}
+	for _, p := range h.Outputs {
+		c.Outputs = append(c.Outputs, Port{Name: p.Name, Width: p.Width, Bus: p.Bus})
+	}
+	c.Simulate, c.Clocked, c.Delay = h.Simulate, h.Clocked, h.Delay
+	c.Loops, c.InitAll, c.Init = h.Loops, h.InitAll, h.Init
+}
+
+//	add adds a chip to the design
+func (d *Design) add(c *Chip, loaded bool) error {
+	if d.Chip(c.Name) != nil {
+		if loaded {
+			return nil
+		}
+		return fmt.Errorf("chip %s is declared more than once", c.Name)
+	}
+	if len(c.Outputs) == 0 {
+		return fmt.Errorf("chip %s has no outputs", c.Name)
+	}
+	d.Chips = append(d.Chips, c)
+	return nil
+}
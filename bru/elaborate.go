@@ -0,0 +1,123 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package bru
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	Circuit is a chip elaborated down to primitive gates, connected by single
+//	bit nets. Every wire of the chip, and of the chips inside it, is known by
+//	its hierarchical name, eg. "fa0.t" for the wire t of the first full adder
+//	used, and every bit of a buffer by its index, eg. "s[2]".
+type Circuit struct {
+	Top *Chip
+
+	n *hdl.Netlist
+}
+
+//	Wires returns the names of every wire in the circuit, sorted
+func (c *Circuit) Wires() []string {
+	var names []string
+	for name := range c.n.Index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//	Elaborate flattens the named chip, and every chip it uses, into a circuit.
+//	If top is empty, the chip marked with SIM is elaborated.
+func (d *Design) Elaborate(top string) (*Circuit, error) {
+	var c *Chip
+	if top == "" {
+		for _, v := range d.Chips {
+			if v.Simulate {
+				if c != nil {
+					return nil, fmt.Errorf("more than one chip is marked SIM: %s and %s", c.Name, v.Name)
+				}
+				c = v
+			}
+		}
+		if c == nil {
+			return nil, errors.New("no chip is marked SIM")
+		}
+	} else if c = d.Chip(top); c == nil {
+		return nil, fmt.Errorf("chip %s not found", top)
+	}
+	built := map[string]*hdl.Chip{}
+	chips := func(name string) (*hdl.Chip, error) {
+		if h, found := built[name]; found {
+			return h, nil
+		}
+		v := d.Chip(name)
+		if v == nil {
+			return nil, nil
+		}
+		h, err := v.elaborable()
+		built[name] = h
+		return h, err
+	}
+	h, err := chips(c.Name)
+	if err != nil {
+		return nil, err
+	}
+	n, err := hdl.Build(h, chips, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Circuit{Top: c, n: n}, nil
+}
+
+//	elaborable returns the chip with the statements in its CON block parsed,
+//	ready to be elaborated. The delays of chips are not simulated.
+func (c *Chip) elaborable() (*hdl.Chip, error) {
+	h := &hdl.Chip{Name: c.Name, InitAll: c.InitAll, Init: c.Init, File: c.File}
+	for _, p := range c.Inputs {
+		h.Inputs = append(h.Inputs, hdl.Port{Name: p.Name, Shape: hdl.Shape{Width: p.Width, Bus: p.Bus}})
+	}
+	for _, p := range c.Outputs {
+		h.Outputs = append(h.Outputs, hdl.Port{Name: p.Name, Shape: hdl.Shape{Width: p.Width, Bus: p.Bus}})
+	}
+	for _, v := range c.body {
+		if !hdl.IsStatement(v.text) {
+			continue
+		}
+		s, err := hdl.ParseStatement(v.text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c.where(v.line), err)
+		}
+		s.Where = c.where(v.line)
+		h.Body = append(h.Body, s)
+	}
+	return h, nil
+}
+
+//	where returns the location of a line of a chip, for error messages
+func (c *Chip) where(line int) string {
+	if c.File == "" {
+		return fmt.Sprintf("line %d", line)
+	}
+	return fmt.Sprintf("%s:%d", c.File, line)
+}
@@ -0,0 +1,196 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package bru
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	SettleLimit is the number of passes over a circuit after which Eval gives
+//	up waiting for it to settle
+const SettleLimit = 1000
+
+//	SettleError is returned by Eval when a circuit does not settle. The wires
+//	that were still changing are set to "X".
+type SettleError struct {
+	Chip  string
+	Wires []string
+}
+
+func (e *SettleError) Error() string {
+	return e.Chip + " did not settle, oscillating wires : " + strings.Join(e.Wires, ", ")
+}
+
+//	Simulator simulates a circuit. Every wire starts out with the value given
+//	to it by INIT, or "X", and the global reset signal starts out as 0.
+type Simulator struct {
+	c     *Circuit
+	st    *hdl.State // value of every net and contents of every memory
+	cycle int        // number of clock cycles stepped
+}
+
+//	NewSimulator returns a simulator for the circuit, with every wire and
+//	memory at its starting value
+func NewSimulator(c *Circuit) *Simulator {
+	return &Simulator{c: c, st: c.n.Start(unknown)}
+}
+
+//	unknown is the value of wires and memories with no starting value
+func unknown() string {
+	return "X"
+}
+
+//	Cycle returns the number of clock cycles stepped so far
+func (s *Simulator) Cycle() int {
+	return s.cycle
+}
+
+//	lookup returns the nets of a wire, eg. "a", "a[2]" or "fa0.t"
+func (s *Simulator) lookup(name string) ([]int, bool, error) {
+	name = strings.TrimPrefix(name, s.c.Top.Name+".")
+	if id, ok := s.c.n.Index[name]; ok {
+		return []int{id}, false, nil
+	}
+	var nets []int
+	for k := 0; ; k++ {
+		id, ok := s.c.n.Index[name+"["+strconv.Itoa(k)+"]"]
+		if !ok {
+			break
+		}
+		nets = append(nets, id)
+	}
+	if nets == nil {
+		return nil, false, fmt.Errorf("unknown wire '%s'", name)
+	}
+	return nets, true, nil
+}
+
+//	Set gives an input of the top chip, or the global reset signal, a value.
+//	Single bits are set to "0", "1" or "X"; buffers take a number, written in
+//	binary ("0b1010"), hexadecimal ("0xA"), decimal ("10") or with its width
+//	given first ("4'hA"). Setting reset to 1 puts the starting contents back
+//	into every memory. The new value is seen by the next Eval or Step.
+func (s *Simulator) Set(name, value string) error {
+	base := strings.TrimPrefix(name, s.c.Top.Name+".")
+	if i := strings.Index(base, "["); i != -1 {
+		base = base[:i]
+	}
+	if base != "reset" && !s.c.n.IsInput(base) {
+		return fmt.Errorf("'%s' is not an input of %s", name, s.c.Top.Name)
+	}
+	nets, bus, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	bits := []string{value}
+	if bus {
+		if bits, err = hdl.ParseNumber(value, len(nets)); err != nil {
+			return err
+		}
+	} else if !hdl.IsBit(value) {
+		return fmt.Errorf("invalid value '%s' for '%s'", value, name)
+	}
+	for k, id := range nets {
+		s.st.Values[id] = bits[k]
+	}
+	if base == "reset" && value == "1" {
+		//	the memories go back to what they started with
+		s.st.Data = s.c.n.Start(unknown).Data
+	}
+	return nil
+}
+
+//	Get returns the value of a wire: "0", "1" or "X" for a single bit, and
+//	the bits of a buffer, most significant first, after "0b"
+func (s *Simulator) Get(name string) (string, error) {
+	bits, err := s.Bits(name)
+	if err != nil {
+		return "", err
+	}
+	if nets, bus, _ := s.lookup(name); !bus && len(nets) == 1 {
+		return bits[0], nil
+	}
+	v := "0b"
+	for k := len(bits) - 1; k >= 0; k-- {
+		v += bits[k]
+	}
+	return v, nil
+}
+
+//	Bits returns the bits of a wire, least significant first
+func (s *Simulator) Bits(name string) ([]string, error) {
+	nets, _, err := s.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	var bits []string
+	for _, id := range nets {
+		bits = append(bits, s.st.Values[id])
+	}
+	return bits, nil
+}
+
+//	Eval evaluates the circuit until no wire changes any more
+func (s *Simulator) Eval() error {
+	oscillating := s.st.Settle(SettleLimit)
+	if oscillating == nil {
+		return nil
+	}
+	err := &SettleError{Chip: s.c.Top.Name}
+	for _, k := range oscillating {
+		err.Wires = append(err.Wires, s.c.n.Names[k][0])
+	}
+	return err
+}
+
+//	Step simulates one clock cycle of a clocked chip: the circuit is
+//	evaluated, and then every looped back output is fed to its input
+func (s *Simulator) Step() error {
+	if !s.c.Top.Clocked {
+		return fmt.Errorf("chip %s is not clocked", s.c.Top.Name)
+	}
+	if len(s.c.Top.Loops) == 0 {
+		return errors.New("chip " + s.c.Top.Name + " has no looped back inputs")
+	}
+	err := s.Eval()
+	for _, loop := range s.c.Top.Loops {
+		bits, e := s.Bits(loop[1])
+		if e != nil {
+			return e
+		}
+		ins, _, e := s.lookup(loop[0])
+		if e != nil {
+			return e
+		}
+		if len(ins) != len(bits) {
+			return fmt.Errorf("%s and %s are of different sizes", loop[0], loop[1])
+		}
+		for k, id := range ins {
+			s.st.Values[id] = bits[k]
+		}
+	}
+	s.cycle++
+	return err
+}
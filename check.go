@@ -0,0 +1,119 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	Scripts can check the values of wires with lines like "expect o1 = 0",
+//	"expect s = [1 0 1 0]" or "assert s[2] = 1" ('expect' and 'assert' mean
+//	the same thing). In a combinational script, a check looks at the values
+//	left by the last 'call'. In a clocked script, checks go inside 't' blocks
+//	and look at the values during that cycle.
+
+//	check is a single expectation from a script
+type check struct {
+	name  string // the wire being checked
+	value string // the value it is expected to have
+	line  string // where the check was made, eg. "adder.scr:12"
+}
+
+//	isCheck reports whether a script line is an expect or assert statement
+func isCheck(line string) bool {
+	return strings.HasPrefix(line, "expect ") || strings.HasPrefix(line, "assert ")
+}
+
+//	parseCheck parses an expect or assert statement. The value expected may
+//	be written like any value given to an input, eg. "0xA" or "X", or as the
+//	value would be printed, eg. "[0 1 0 1]".
+func parseCheck(line, where string) (check, error) {
+	line = strings.Replace(strings.TrimSpace(line[len("expect"):]), "==", "=", 1)
+	i := strings.Index(line, "=")
+	if i == -1 {
+		return check{}, newError(parseError, "expected 'expect wire = value'")
+	}
+	c := check{name: strings.TrimSpace(line[:i]), value: strings.TrimSpace(line[i+1:]), line: where}
+	if c.name == "" || c.value == "" {
+		return check{}, newError(parseError, "expected 'expect wire = value'")
+	}
+	if strings.HasPrefix(c.value, "[") {
+		bits := strings.Fields(strings.Trim(c.value, "[]"))
+		for _, b := range bits {
+			if !hdl.IsBit(b) {
+				return check{}, newError(parseError, "invalid value '%s' for '%s'", b, c.name)
+			}
+		}
+		c.value = "[" + strings.Join(bits, " ") + "]"
+	}
+	return c, nil
+}
+
+//	verify compares a wire with the value it is expected to have, recording
+//	the result in the netlist
+func (n *netlist) verify(c check) error {
+	got, err := n.get(c.name)
+	if err != nil {
+		return err
+	}
+	want := c.value
+	if !strings.HasPrefix(want, "[") {
+		nets, _ := n.lookup(c.name)
+		bits, err := hdl.ParseNumber(want, len(nets))
+		if err != nil {
+			return newError(parseError, "%s : %s: %v", c.line, c.name, err)
+		}
+		want = n.format(c.name, bits)
+	}
+	passed := got == want
+	if resultFormat != "raw" {
+		want, got = n.display(c.name, want), n.formatValue(c.name)
+	}
+	n.checkResults = append(n.checkResults, checkResult{c, len(n.results) - 1, want, got, passed})
+	if passed {
+		n.passed++
+		return nil
+	}
+	n.failures = append(n.failures, fmt.Sprintf("%s : expected %s = %s, got %s", c.line, c.name, want, got))
+	return nil
+}
+
+//	reportChecks prints how many checks passed and failed, followed by the
+//	failed checks. It returns the number of checks that failed.
+func reportChecks(nets []*netlist, out io.Writer) int {
+	passed := 0
+	var failures []string
+	for _, n := range nets {
+		passed += n.passed
+		failures = append(failures, n.failures...)
+	}
+	if passed+len(failures) == 0 {
+		return 0
+	}
+	fmt.Fprintf(out, "CHECKS : %d passed, %d failed\n", passed, len(failures))
+	for _, f := range failures {
+		fmt.Fprintln(out, "FAIL : "+f)
+	}
+	return len(failures)
+}
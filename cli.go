@@ -0,0 +1,547 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	Bru is run as "bru command [arguments]". Options may be given anywhere
+//	after the command, as "--name value", "--name=value" or, for those that
+//	don't take a value, just "--name". Everything after "--" is an argument.
+
+//	exit statuses of bru
+const (
+	exitOK       = 0 // everything worked, and every check and test passed
+	exitFailed   = 1 // a check or test failed, or a file was not formatted
+	exitError    = 2 // bru was used wrongly, or could not do what it was asked
+	exitFile     = 3 // a file could not be read or written
+	exitParse    = 4 // an hdl file or script is malformed
+	exitSemantic = 5 // a design can't be built, eg. it uses an unknown chip
+	exitScript   = 6 // a script can't be run or translated against its chip
+)
+
+const usage = `usage: bru command [arguments]
+
+commands:
+    run     simulate chips with scripts, or interactively with -i
+    build   translate a chip and its script into a go program
+    check   read hdl files and report the chips that can't be built
+    table   print the truth table of a chip
+    test    compare the results of scripts with their golden files
+    fmt     lay out hdl files the standard way
+    help    print help about a command
+
+Run 'bru help command' for the arguments and options of a command.
+
+exit status:
+    0  everything worked
+    1  a check or test failed, or a file was not formatted
+    2  bru was used wrongly
+    3  a file could not be read or written
+    4  an hdl file or script is malformed
+    5  a design can't be built
+    6  a script can't be run against its chip`
+
+//	command is something bru can be asked to do, eg. "bru run"
+type command struct {
+	name    string
+	help    string
+	options string // options taken, "name=" for those with a value
+	run     func(args []string, opts map[string]string) int
+}
+
+//	simOptions are the options taken by commands that simulate chips
+const simOptions = "top= init= delay= trace hazards dump= vcd= wave radix= format= probe= results="
+
+var commands = []command{
+	{"run", `usage: bru run file.hdl script... [options]
+       bru run file.hdl -i [options]
+
+Simulates the chips marked SIM in file.hdl with the given scripts, one script
+per chip, and prints the results. With -i, the chip is simulated
+interactively instead, with commands typed in one at a time.
+
+options:
+    -t, --timed          simulate with gate delays
+    -i, --interactive    start an interactive session
+    --top chip           simulate this chip, ignoring the SIM flags
+    --init v             value wires start with: 0, 1, X or random
+    --delay spec         delays of the gates, eg. and=2,or=2,not=1
+    --trace              print every change of a wire (with --timed)
+    --hazards            report glitches (implies --timed)
+    --probe wires        print these wires along with the outputs, eg. fa0.t,c1
+    --radix r            print buses in bin, hex, unsigned or signed
+    --format f           write results as text, raw, table, csv or json
+    --vcd file           write every wire to a VCD file
+    --wave               draw the results as a timing diagram
+    --dump file          write the contents of memories to file ('-' prints them)
+    --results file       write the results of clocked chips to file
+
+Exits with status 1 if a check made by a script fails.`,
+		"timed interactive " + simOptions, runRun},
+	{"build", `usage: bru build file.hdl [script] [options]
+       bru build file.hdl --package name [options]
+       bru build file.hdl script... --package name --tests [options]
+
+Translates the chips in file.hdl into go code, written to main.go. If a chip
+is marked SIM, a main function running the script against it is added. With
+--package, a package that other go code can import is written instead, to
+name.go, with an exported function for every chip. With --tests as well, the
+scripts are translated into go tests for that package instead, written to
+name_test.go. Bru only replaces files it generated itself.
+
+options:
+    -o, --out file       write the go code to file instead ('-' prints it)
+    --package name       write a package with this name instead of a program
+    --tests              write go tests for the package, made from the scripts
+    --top chip           simulate this chip, ignoring the SIM flags
+    --init v             value wires start with: 0, 1, X or random
+    --results file       file the program writes the results of a clocked chip
+                         to (required for clocked chips)`,
+		"out= package= tests top= init= results=", runBuild},
+	{"check", `usage: bru check file.hdl...
+
+Reads the given hdl files and builds every chip in them, reporting the chips
+that can't be built and why. Nothing is simulated.`,
+		"", runCheck},
+	{"table", `usage: bru table file.hdl [chip] [options]
+
+Prints the truth table of the named chip, or of the chip marked SIM.
+
+options:
+    --with-x             also try X for every input
+    --init v             value wires start with: 0, 1, X or random
+    --probe wires        add these wires to the table, eg. fa0.t,c1
+    --radix r            print buses in bin, hex, unsigned or signed`,
+		"with-x init= probe= radix=", runTableCommand},
+	{"test", `usage: bru test file.hdl script... [file.hdl script...]... [options]
+
+Runs every script against the hdl file before it, and compares what it prints
+with the script's golden file (the script's name followed by .golden).
+
+options:
+    --update             write the golden files instead of comparing
+
+Exits with status 1 if a test fails.`,
+		"update init= radix=", runTestCommand},
+	{"fmt", `usage: bru fmt file.hdl... [options]
+
+Lays out the given hdl files the standard way and prints the names of the files
+that changed.
+
+options:
+    --check              only print the names of files that aren't laid out
+                         the standard way, and exit with status 1 if there are any`,
+		"check", runFmt},
+}
+
+//	shortNames are the one letter names of options
+var shortNames = map[string]string{"t": "timed", "i": "interactive", "h": "help", "o": "out"}
+
+//	findCommand returns the command with the given name
+func findCommand(name string) *command {
+	for k := range commands {
+		if commands[k].name == name {
+			return &commands[k]
+		}
+	}
+	return nil
+}
+
+//	runCLI runs the command given by the arguments and returns the status bru
+//	should exit with
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(usage)
+		return exitError
+	}
+	switch args[0] {
+	case "help", "-h", "--help":
+		if len(args) > 1 {
+			if c := findCommand(args[1]); c != nil {
+				fmt.Println(c.help)
+				return exitOK
+			}
+			fmt.Fprintln(messages, "ERROR: unknown command '"+args[1]+"'")
+			return exitError
+		}
+		fmt.Println(usage)
+		return exitOK
+	}
+	c := findCommand(args[0])
+	if c == nil {
+		fmt.Fprintln(messages, "ERROR: unknown command '"+args[0]+"'. Run 'bru help' for a list of commands.")
+		if strings.HasSuffix(args[0], ".hdl") {
+			fmt.Fprintln(messages, "\tThe command comes first now, eg. 'bru run "+args[0]+" script'.")
+		}
+		return exitError
+	}
+	rest, opts, err := c.parse(args[1:])
+	setMessages(opts)
+	if err != nil {
+		fmt.Fprintln(messages, "ERROR: "+err.Error()+". Run 'bru help "+c.name+"' for its options.")
+		return exitError
+	}
+	if _, ok := opts["help"]; ok {
+		fmt.Println(c.help)
+		return exitOK
+	}
+	if err := applyOptions(opts); err != nil {
+		fmt.Fprintln(messages, "ERROR: "+err.Error())
+		return exitError
+	}
+	return c.run(rest, opts)
+}
+
+//	parse separates the options given to a command from its arguments. The
+//	options read before an error are returned along with it.
+func (c *command) parse(args []string) ([]string, map[string]string, error) {
+	takes := map[string]bool{"help": false}
+	for _, v := range strings.Fields(c.options) {
+		takes[strings.TrimSuffix(v, "=")] = strings.HasSuffix(v, "=")
+	}
+	var rest []string
+	opts := map[string]string{}
+	for k := 0; k < len(args); k++ {
+		v := args[k]
+		if v == "--" {
+			rest = append(rest, args[k+1:]...)
+			break
+		}
+		if !strings.HasPrefix(v, "-") || v == "-" {
+			rest = append(rest, v)
+			continue
+		}
+		name, value, hasValue := strings.TrimLeft(v, "-"), "", false
+		if i := strings.Index(name, "="); i != -1 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		if long, ok := shortNames[name]; ok && !strings.HasPrefix(v, "--") {
+			name = long
+		}
+		withValue, ok := takes[name]
+		switch {
+		case !ok:
+			return nil, opts, fmt.Errorf("bru %s has no option '%s'", c.name, v)
+		case withValue && !hasValue:
+			if k+1 == len(args) {
+				return nil, opts, fmt.Errorf("option --%s needs a value", name)
+			}
+			k++
+			value = args[k]
+		case !withValue && hasValue:
+			return nil, opts, fmt.Errorf("option --%s doesn't take a value", name)
+		}
+		opts[name] = value
+	}
+	return rest, opts, nil
+}
+
+//	setMessages sends warnings and errors to stderr when stdout carries what
+//	other programs read: go code printed with '-o -', or csv and json results
+func setMessages(opts map[string]string) {
+	if opts["out"] == "-" || opts["format"] == "csv" || opts["format"] == "json" {
+		messages = os.Stderr
+	}
+}
+
+//	applyOptions sets the options shared by several commands
+func applyOptions(opts map[string]string) error {
+	for name, v := range opts {
+		switch name {
+		case "delay":
+			if err := parseDelays(v); err != nil {
+				return err
+			}
+		case "trace":
+			traceNets = true
+		case "hazards":
+			findHazards = true
+		case "dump":
+			dumpFile = v
+		case "top":
+			topChip = v
+		case "vcd":
+			vcdFile = v
+		case "wave":
+			drawWaves = true
+		case "results":
+			outFileName = v
+		case "probe":
+			probeList = strings.Split(v, ",")
+		case "radix":
+			if !isRadix(v) {
+				return errors.New("--radix must be bin, hex, unsigned or signed")
+			}
+			radixName = v
+		case "format":
+			if !isFormat(v) {
+				return errors.New("--format must be text, raw, table, csv or json")
+			}
+			resultFormat = v
+		case "init":
+			if !hdl.IsBit(v) && v != "random" {
+				return errors.New("--init must be 0, 1, X or random")
+			}
+			initValue = v
+		}
+	}
+	return nil
+}
+
+//	usageError reports a command used with the wrong arguments
+func usageError(name, problem string) int {
+	fmt.Fprintln(messages, "ERROR: "+problem+". Run 'bru help "+name+"' for its usage.")
+	return exitError
+}
+
+//	readTop reads an hdl file and makes sure the chip picked with --top, if
+//	any, is in it
+func readTop(hdlFile string) error {
+	if err := readHDL(hdlFile); err != nil {
+		return err
+	}
+	if topChip != "" && numSim == 0 {
+		return newError(semanticError, "chip %s not found.", topChip)
+	}
+	return nil
+}
+
+//	runRun simulates chips in-process, or starts an interactive session
+func runRun(args []string, opts map[string]string) int {
+	_, timed := opts["timed"]
+	_, interactive := opts["interactive"]
+	if len(args) == 0 {
+		return usageError("run", "no hdl file given")
+	}
+	if interactive && len(args) > 1 {
+		return usageError("run", "scripts can't be given with -i")
+	}
+	if !interactive && len(args) == 1 {
+		return usageError("run", "no script given")
+	}
+	if err := readTop(args[0]); err != nil {
+		return reportError(err)
+	}
+	if interactive {
+		runRepl(args[0])
+		return exitOK
+	}
+	return runEval(args[1:], timed, findHazards)
+}
+
+//	runBuild translates a chip and its script into a go program
+func runBuild(args []string, opts map[string]string) int {
+	if len(args) == 0 {
+		return usageError("build", "no hdl file given")
+	}
+	pkg, asPackage := opts["package"]
+	_, asTests := opts["tests"]
+	switch {
+	case len(args) > 2 && !asTests:
+		return usageError("build", "only one script can be translated at a time")
+	case asTests && !asPackage:
+		return usageError("build", "--tests needs --package, naming the package tested")
+	case asTests && len(args) == 1:
+		return usageError("build", "no script given to translate into tests")
+	case asPackage && !asTests && len(args) > 1:
+		return usageError("build", "scripts can only be translated into tests for a package, with --tests")
+	}
+	if asPackage && (!token.IsIdentifier(pkg) || pkg == "main") {
+		return usageError("build", "'"+pkg+"' can't be the name of a package")
+	}
+	out, ok := opts["out"]
+	if !ok {
+		out = "main.go"
+		if asTests {
+			out = pkg + "_test.go"
+		} else if asPackage {
+			out = pkg + ".go"
+		}
+	}
+	if err := readTop(args[0]); err != nil {
+		return reportError(err)
+	}
+	var code string
+	if asTests {
+		var err error
+		if code, err = testCode(pkg, args[1:]); err != nil {
+			return reportError(err)
+		}
+	} else if asPackage {
+		var err error
+		if code, err = packageCode(pkg, args[0]); err != nil {
+			return reportError(err)
+		}
+	} else {
+		if err := checkChips(); err != nil {
+			return reportError(err)
+		}
+		mainFuncCode += "$\n"
+		goEquivOutput += mainFuncCode
+		script := ""
+		if len(args) == 2 {
+			script = args[1]
+		}
+		if err := ui(script); err != nil {
+			return reportError(err)
+		}
+		code = generatedHeader + "\n\n" + finalGo + "\n"
+	}
+	if out == "-" {
+		fmt.Print(code)
+		return exitOK
+	}
+	if err := canReplace(out); err != nil {
+		return reportError(err)
+	}
+	if err := writeToFile(out, code); err != nil {
+		return reportError(err)
+	}
+	return exitOK
+}
+
+//	checkChips makes sure every chip read can be elaborated, since the go code
+//	of a program is put together from the hdl without looking at it closely
+func checkChips() error {
+	for k := range chips {
+		c := &chips[k]
+		if _, err := elaborable(c); err != nil {
+			return err
+		}
+		if c.simulate {
+			if _, err := buildNetlist(c, chips); err != nil {
+				return classify(semanticError, err)
+			}
+		}
+	}
+	return nil
+}
+
+//	generatedHeader is the first line of every file of go code bru writes, in
+//	the form go tools recognise as marking generated code
+const generatedHeader = "// Code generated by bru. DO NOT EDIT."
+
+//	canReplace makes sure a file bru is about to write go code to either
+//	doesn't exist, or was written by bru, so that nobody's own code is lost
+func canReplace(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return &bruError{fileError, err}
+	}
+	if !strings.HasPrefix(string(data), generatedHeader) {
+		return newError(fileError, "%s was not generated by bru, and won't be replaced.\n\tTry using '-o file' to write the code somewhere else.", filename)
+	}
+	return nil
+}
+
+//	runCheck builds every chip in the given hdl files, without simulating them
+func runCheck(args []string, opts map[string]string) int {
+	if len(args) == 0 {
+		return usageError("check", "no hdl file given")
+	}
+	status := exitOK
+	for _, f := range args {
+		if err := readHDL(f); err != nil {
+			status = reportError(err)
+			continue
+		}
+		if len(chips) == 0 {
+			status = reportError(newError(parseError, "%s: no chips found", f))
+			continue
+		}
+		bad := 0
+		for k := range chips {
+			if _, err := buildNetlist(&chips[k], chips); err != nil {
+				status = reportError(fmt.Errorf("%s: %w", f, classify(semanticError, err)))
+				bad++
+			}
+		}
+		if bad > 0 {
+			continue
+		}
+		fmt.Printf("ok   : %s (%d chips)\n", f, len(chips))
+	}
+	return status
+}
+
+//	runTableCommand prints the truth table of a chip
+func runTableCommand(args []string, opts map[string]string) int {
+	if len(args) == 0 || len(args) > 2 {
+		return usageError("table", "expected an hdl file and, optionally, a chip")
+	}
+	_, withX := opts["with-x"]
+	if err := readHDL(args[0]); err != nil {
+		return reportError(err)
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	return runTable(name, withX)
+}
+
+//	runTestCommand runs golden tests
+func runTestCommand(args []string, opts map[string]string) int {
+	_, update := opts["update"]
+	return runTests(args, update)
+}
+
+//	runFmt lays out hdl files the standard way
+func runFmt(args []string, opts map[string]string) int {
+	if len(args) == 0 {
+		return usageError("fmt", "no hdl file given")
+	}
+	_, check := opts["check"]
+	status := exitOK
+	for _, f := range args {
+		data, err := loadFile(f)
+		if err != nil {
+			status = reportError(err)
+			continue
+		}
+		formatted := formatHDL(data)
+		if formatted == data {
+			continue
+		}
+		fmt.Println(f)
+		if check {
+			if status == exitOK {
+				status = exitFailed
+			}
+			continue
+		}
+		if err := writeToFile(f, formatted); err != nil {
+			status = reportError(err)
+		}
+	}
+	return status
+}
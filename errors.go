@@ -0,0 +1,92 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+//	Everything that can go wrong is returned as an error, up to the command
+//	being run, which prints it and exits with the status for its kind. That
+//	way scripts and build systems running bru can tell a missing file from a
+//	broken design. Checks that fail are not errors: they are reported along
+//	with the results, and bru exits with exitFailed.
+
+//	kinds of errors
+const (
+	fileError     = iota // a file could not be read or written
+	parseError           // an hdl file or script is malformed
+	semanticError        // a design reads fine but can't be built, eg. an unknown chip
+	scriptError          // a script can't be run or translated against its chip
+)
+
+//	bruError is an error of a known kind
+type bruError struct {
+	kind int
+	err  error
+}
+
+func (e *bruError) Error() string {
+	return e.err.Error()
+}
+
+func (e *bruError) Unwrap() error {
+	return e.err
+}
+
+//	newError returns an error of the given kind
+func newError(kind int, format string, args ...interface{}) error {
+	return &bruError{kind, fmt.Errorf(format, args...)}
+}
+
+//	classify gives err the given kind, unless it already has one
+func classify(kind int, err error) error {
+	var e *bruError
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	return &bruError{kind, err}
+}
+
+//	exitStatus returns the status bru exits with after the given error
+func exitStatus(err error) int {
+	var e *bruError
+	if !errors.As(err, &e) {
+		return exitError
+	}
+	switch e.kind {
+	case fileError:
+		return exitFile
+	case parseError:
+		return exitParse
+	case semanticError:
+		return exitSemantic
+	case scriptError:
+		return exitScript
+	}
+	return exitError
+}
+
+//	reportError prints an error and returns the status bru exits with after it
+func reportError(err error) int {
+	fmt.Fprintln(messages, "ERROR: "+err.Error())
+	return exitStatus(err)
+}
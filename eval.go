@@ -0,0 +1,237 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	The evaluator is an alternative to generating go code. It elaborates the
+//	chip being simulated into a flat list of gates (a netlist) connected by
+//	single bit nets, using the same elaborator as the bru package, and
+//	evaluates that list in-process. Because a net may be read before the
+//	statement that drives it has been evaluated, the netlist is evaluated
+//	repeatedly until no net changes any more. This is what lets cross coupled
+//	gates, like the two nands of an SR latch, be described in the HDL.
+
+//	settleLimit is the number of passes over the netlist after which a design
+//	that still has not settled is considered to be oscillating.
+var settleLimit int = 1000
+
+//	netlist is a chip elaborated down to primitive gates, along with the
+//	value of every net in it and what is needed to simulate it
+type netlist struct {
+	*hdl.State
+	top          *chip
+	ins          []hdl.Port    // inputs of the top level chip
+	outs         []hdl.Port    // outputs of the top level chip
+	initial      [][][]string  // contents every memory started with
+	timed        *timedSim     // if not nil, the netlist is simulated with delays
+	probes       []string      // wires printed along with the outputs
+	passed       int           // number of checks that passed
+	failures     []string      // checks that failed
+	vcd          *vcdWriter    // if not nil, the wires are written to a VCD file
+	wave         *waveWriter   // if not nil, the wires are drawn as waveforms
+	results      []result      // results kept to be written with --format
+	checkResults []checkResult // every check made
+	radix        string        // radix buses are printed in, if the script chose one
+	calls        int           // number of calls made so far
+	cycleInputs  []string      // value of every net during the last cycle, before feedback
+}
+
+//	parsePorts splits the IN or OUT list of a chip into its individual ports.
+//	Looped back inputs, written as (in|out), are declared by their input name.
+func parsePorts(list string) ([]hdl.Port, error) {
+	var ports []hdl.Port
+	for _, v := range strings.Fields(list) {
+		if strings.HasPrefix(v, "(") && strings.Contains(v, "|") {
+			v = v[1:strings.Index(v, "|")]
+		}
+		p, err := hdl.ParsePort(v)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+//	parseStatements parses the assignments in the CON block of a chip. Lines
+//	without an assignment are ignored, just like they are when go code is
+//	generated.
+func parseStatements(c *chip) ([]hdl.Stmt, error) {
+	var stmts []hdl.Stmt
+	for _, v := range returnLines(c.commands) {
+		if !hdl.IsStatement(v) {
+			continue
+		}
+		s, err := hdl.ParseStatement(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", v, err)
+		}
+		s.Where = c.name + ": " + v
+		stmts = append(stmts, s)
+	}
+	return stmts, nil
+}
+
+//	gateDelays holds the delay of every kind of primitive gate
+var gateDelays = map[string]int{"and": 1, "or": 1, "not": 1, "ram": 1, "rom": 1}
+
+//	elaborable returns a chip with its ports and statements parsed, ready to
+//	be elaborated
+func elaborable(c *chip) (*hdl.Chip, error) {
+	h := &hdl.Chip{Name: c.name, Delay: c.delay, InitAll: c.initAll, Init: c.init, File: chipFiles[c.name]}
+	var err error
+	if h.Inputs, err = parsePorts(c.args); err != nil {
+		return nil, newError(parseError, "%s: %v", c.name, err)
+	}
+	if h.Outputs, err = parsePorts(strings.Join(c.outputs, " ")); err != nil {
+		return nil, newError(parseError, "%s: %v", c.name, err)
+	}
+	if h.Body, err = parseStatements(c); err != nil {
+		return nil, newError(parseError, "%s: %v", c.name, err)
+	}
+	return h, nil
+}
+
+//	buildNetlist elaborates the given chip, and everything it uses, into a
+//	netlist. chips holds every chip that may be instantiated.
+func buildNetlist(top *chip, chips []chip) (*netlist, error) {
+	built := map[string]*hdl.Chip{}
+	lookup := func(name string) (*hdl.Chip, error) {
+		if h, found := built[name]; found {
+			return h, nil
+		}
+		for k := range chips {
+			if chips[k].name == name {
+				h, err := elaborable(&chips[k])
+				built[name] = h
+				return h, err
+			}
+		}
+		return nil, nil
+	}
+	h, err := lookup(top.name)
+	if err != nil {
+		return nil, err
+	}
+	nl, err := hdl.Build(h, lookup, gateDelays)
+	var missing *os.PathError
+	if errors.As(err, &missing) {
+		//	the contents of a memory could not be read
+		return nil, &bruError{fileError, err}
+	} else if err != nil {
+		return nil, err
+	}
+	n := &netlist{State: nl.Start(startingValue), top: top, ins: h.Inputs, outs: h.Outputs}
+	for _, data := range n.Data {
+		var words [][]string
+		for _, word := range data {
+			words = append(words, append([]string(nil), word...))
+		}
+		n.initial = append(n.initial, words)
+	}
+	return n, nil
+}
+
+//	netNames returns the first name of each of the given nets
+func (n *netlist) netNames(nets []int) []string {
+	var names []string
+	for _, k := range nets {
+		names = append(names, n.Names[k][0])
+	}
+	return names
+}
+
+//	lookup returns the nets of a top level wire, eg. "a" or "a[2]"
+func (n *netlist) lookup(name string) ([]int, error) {
+	if strings.HasPrefix(name, n.top.name+".") {
+		name = name[len(n.top.name)+1:]
+	}
+	if id, ok := n.Index[name]; ok {
+		return []int{id}, nil
+	}
+	var nets []int
+	for k := 0; ; k++ {
+		id, ok := n.Index[name+"["+strconv.Itoa(k)+"]"]
+		if !ok {
+			break
+		}
+		nets = append(nets, id)
+	}
+	if nets == nil {
+		return nil, fmt.Errorf("unknown wire '%s'", name)
+	}
+	return nets, nil
+}
+
+//	set assigns a value to every bit of a top level wire
+func (n *netlist) set(name, value string) error {
+	nets, err := n.lookup(name)
+	if err != nil {
+		return err
+	}
+	for _, k := range nets {
+		n.Values[k] = value
+	}
+	return nil
+}
+
+//	get returns the value of a top level wire, formatted the way go prints
+//	strings and arrays of strings
+func (n *netlist) get(name string) (string, error) {
+	nets, err := n.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	var bits []string
+	for _, k := range nets {
+		bits = append(bits, n.Values[k])
+	}
+	return n.format(name, bits), nil
+}
+
+//	format writes the bits of a wire the way get does
+func (n *netlist) format(name string, bits []string) string {
+	if _, ok := n.Index[strings.TrimPrefix(name, n.top.name+".")]; ok {
+		return bits[0]
+	}
+	return "[" + strings.Join(bits, " ") + "]"
+}
+
+//	evaluate settles the netlist and warns about any nets left oscillating
+func (n *netlist) evaluate() {
+	var osc []int
+	if n.timed != nil {
+		osc = n.timed.run()
+	} else {
+		osc = n.Settle(settleLimit)
+	}
+	if osc != nil {
+		fmt.Fprintln(messages, "WARNING : "+n.top.name+" did not settle, oscillating nets : "+strings.Join(n.netNames(osc), ", "))
+	}
+}
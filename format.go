@@ -0,0 +1,266 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//	Results are normally printed a line per 'call' or cycle, the way the
+//	generated go code prints them. With --format, they are written once the
+//	script has run instead, along with the names of the ports, the values
+//	of the inputs, the number of the cycle (or call) and the status of the
+//	checks made:
+//
+//		--format table   a table with a column per port
+//		--format csv     the same table, as CSV
+//		--format json    a single JSON document covering every simulation
+//
+//	Buses are written in the radix chosen with --radix, binary by default.
+
+//	resultFormat is the format given with --format
+var resultFormat string = "text"
+
+//	result holds the values of the ports of the chip after a call or cycle
+type result struct {
+	inputs    []string
+	outputs   []string
+	probes    []string
+	settled   int  // time taken to settle, or -1 if simulated without delays
+	abandoned bool // whether the simulation gave up before it settled
+}
+
+//	checkResult is the outcome of a check
+type checkResult struct {
+	check
+	cycle  int // the call or cycle the check was made after, -1 if none
+	want   string
+	got    string
+	passed bool
+}
+
+//	isFormat reports whether name is a format --format accepts
+func isFormat(name string) bool {
+	return name == "text" || name == "raw" || name == "table" || name == "csv" || name == "json"
+}
+
+//	printing reports whether results are printed as they are produced
+func (n *netlist) printing() bool {
+	return n.wave == nil && (resultFormat == "text" || resultFormat == "raw")
+}
+
+//	record keeps the values of the ports of the chip, when they are written
+//	once the script has run
+func (n *netlist) record() {
+	if resultFormat == "text" || resultFormat == "raw" {
+		return
+	}
+	r := result{settled: -1}
+	for _, p := range n.ins {
+		r.inputs = append(r.inputs, n.formatBits(n.cycleBits(p.Name), p.Bus))
+	}
+	for _, p := range n.outs {
+		r.outputs = append(r.outputs, n.formatValue(p.Name))
+	}
+	for _, name := range n.probes {
+		r.probes = append(r.probes, n.formatValue(name))
+	}
+	if n.timed != nil {
+		r.settled, r.abandoned = n.timed.settled, n.timed.abandoned
+	}
+	n.results = append(n.results, r)
+}
+
+//	formatValue returns the value of a wire as written by --format
+func (n *netlist) formatValue(name string) string {
+	nets, _ := n.lookup(name)
+	var bits []string
+	for _, k := range nets {
+		bits = append(bits, n.Values[k])
+	}
+	_, single := n.Index[strings.TrimPrefix(name, n.top.name+".")]
+	return n.formatBits(bits, !single)
+}
+
+//	cycleBits returns the bits of an input as the chip saw them during the
+//	last cycle, before the looped back outputs were fed to it
+func (n *netlist) cycleBits(name string) []string {
+	values := n.Values
+	if n.cycleInputs != nil {
+		values = n.cycleInputs
+	}
+	nets, _ := n.lookup(name)
+	var bits []string
+	for _, k := range nets {
+		bits = append(bits, values[k])
+	}
+	return bits
+}
+
+//	status describes the checks made after a call or cycle: "" if there were
+//	none, "pass" if they all passed and "fail" otherwise
+func (n *netlist) status(cycle int) string {
+	s := ""
+	for _, c := range n.checkResults {
+		if c.cycle != cycle {
+			continue
+		}
+		if !c.passed {
+			return "fail"
+		}
+		s = "pass"
+	}
+	return s
+}
+
+//	writeResults writes the results of a simulation as a table or as CSV
+func (n *netlist) writeResults(out io.Writer) {
+	header := []string{"cycle"}
+	for _, p := range n.ins {
+		header = append(header, p.Name)
+	}
+	for _, p := range n.outs {
+		header = append(header, p.Name)
+	}
+	header = append(header, n.probes...)
+	timed := len(n.results) > 0 && n.results[0].settled != -1
+	if timed {
+		header = append(header, "settled")
+	}
+	header = append(header, "checks")
+	rows := [][]string{header}
+	for k, r := range n.results {
+		row := append([]string{fmt.Sprint(k)}, r.inputs...)
+		row = append(row, r.outputs...)
+		row = append(row, r.probes...)
+		for len(row) < len(header)-1-boolToInt(timed) {
+			//	probes added after this result was recorded
+			row = append(row, "")
+		}
+		if timed && r.abandoned {
+			row = append(row, "-")
+		} else if timed {
+			row = append(row, fmt.Sprint(r.settled))
+		}
+		rows = append(rows, append(row, n.status(k)))
+	}
+	if resultFormat == "csv" {
+		w := csv.NewWriter(out)
+		w.WriteAll(rows)
+		return
+	}
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for k, v := range row {
+			if len(v) > widths[k] {
+				widths[k] = len(v)
+			}
+		}
+	}
+	for _, row := range rows {
+		line := ""
+		for k, v := range row {
+			line += fmt.Sprintf("%-*s ", widths[k], v)
+		}
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+}
+
+//	boolToInt turns true into 1 and false into 0
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//	jsonCheck is a check as written to JSON
+type jsonCheck struct {
+	Line     string `json:"line"`
+	Cycle    int    `json:"cycle"`
+	Wire     string `json:"wire"`
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+	Passed   bool   `json:"passed"`
+}
+
+//	jsonResult is a call or cycle as written to JSON
+type jsonResult struct {
+	Cycle   int               `json:"cycle"`
+	Inputs  map[string]string `json:"inputs"`
+	Outputs map[string]string `json:"outputs"`
+	Probes  map[string]string `json:"probes,omitempty"`
+	Settled *int              `json:"settled,omitempty"`
+}
+
+//	jsonSimulation is a simulation as written to JSON
+type jsonSimulation struct {
+	Chip    string       `json:"chip"`
+	Results []jsonResult `json:"results"`
+	Checks  []jsonCheck  `json:"checks"`
+}
+
+//	writeJSON writes the results of every simulation as a JSON document
+func writeJSON(nets []*netlist, out io.Writer) error {
+	doc := struct {
+		Simulations []jsonSimulation `json:"simulations"`
+		Passed      int              `json:"passed"`
+		Failed      int              `json:"failed"`
+	}{Simulations: []jsonSimulation{}}
+	for _, n := range nets {
+		sim := jsonSimulation{Chip: n.top.name, Results: []jsonResult{}, Checks: []jsonCheck{}}
+		for k, r := range n.results {
+			jr := jsonResult{Cycle: k, Inputs: map[string]string{}, Outputs: map[string]string{}}
+			for i, p := range n.ins {
+				jr.Inputs[p.Name] = r.inputs[i]
+			}
+			for i, p := range n.outs {
+				jr.Outputs[p.Name] = r.outputs[i]
+			}
+			for i, v := range r.probes {
+				if jr.Probes == nil {
+					jr.Probes = map[string]string{}
+				}
+				jr.Probes[n.probes[i]] = v
+			}
+			if r.settled != -1 && !r.abandoned {
+				settled := r.settled
+				jr.Settled = &settled
+			}
+			sim.Results = append(sim.Results, jr)
+		}
+		for _, c := range n.checkResults {
+			sim.Checks = append(sim.Checks, jsonCheck{c.line, c.cycle, c.name, c.want, c.got, c.passed})
+		}
+		doc.Simulations = append(doc.Simulations, sim)
+		doc.Passed += n.passed
+		doc.Failed += len(n.failures)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
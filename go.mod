@@ -0,0 +1,3 @@
+module github.com/ashvin-godbole/bru
+
+go 1.13
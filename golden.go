@@ -0,0 +1,180 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//	Golden tests run scripts and compare what they print with the output
+//	they are known to produce, kept in a golden file next to every script
+//	(the script's name followed by ".golden"). They are run with
+//
+//		bru test adder.hdl adder_script other_script nand.hdl nand_script
+//
+//	where every hdl file is followed by the scripts to run against it.
+
+//	goldenTest is a single script to be run against an hdl file
+type goldenTest struct {
+	hdlFile string
+	script  string
+}
+
+//	golden returns the name of the file holding the expected output of a test
+func (t goldenTest) golden() string {
+	return t.script + ".golden"
+}
+
+//	run simulates the script of a test and returns everything it prints
+func (t goldenTest) run() (string, error) {
+	if _, err := os.Stat(t.hdlFile); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(t.script); err != nil {
+		return "", err
+	}
+	if err := readHDL(t.hdlFile); err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	nets, err := evalScripts([]string{t.script}, false, false, &out, nil)
+	if err != nil {
+		return "", err
+	}
+	reportChecks(nets, &out)
+	return out.String(), nil
+}
+
+//	parseTests reads the tests given on the command line
+func parseTests(args []string) ([]goldenTest, error) {
+	var tests []goldenTest
+	hdlFile := ""
+	for _, v := range args {
+		if strings.HasSuffix(v, ".hdl") {
+			if hdlFile != "" && (len(tests) == 0 || tests[len(tests)-1].hdlFile != hdlFile) {
+				return nil, errors.New("no scripts given for " + hdlFile)
+			}
+			hdlFile = v
+			continue
+		}
+		if hdlFile == "" {
+			return nil, errors.New("script " + v + " given before any hdl file")
+		}
+		tests = append(tests, goldenTest{hdlFile, v})
+	}
+	if len(tests) == 0 || tests[len(tests)-1].hdlFile != hdlFile {
+		return nil, errors.New("usage: bru test file.hdl script... [--update]")
+	}
+	return tests, nil
+}
+
+//	runTests runs golden tests, printing a diff for every test whose output
+//	differs from its golden file. With update set, the golden files are
+//	written instead. The status returned is exitFailed if any test failed.
+func runTests(args []string, update bool) int {
+	tests, err := parseTests(args)
+	if err != nil {
+		fmt.Fprintln(messages, "ERROR: "+err.Error())
+		return exitError
+	}
+	passed, failed := 0, 0
+	for _, t := range tests {
+		name := t.script + " (" + t.hdlFile + ")"
+		got, err := t.run()
+		if err != nil {
+			fmt.Println("FAIL : " + name + " : " + err.Error())
+			failed++
+			continue
+		}
+		if update {
+			if err := writeToFile(t.golden(), got); err != nil {
+				return reportError(err)
+			}
+			fmt.Println("updated " + t.golden())
+			passed++
+			continue
+		}
+		want, err := ioutil.ReadFile(t.golden())
+		if err != nil {
+			fmt.Println("FAIL : " + name + " : no golden file " + t.golden() + ", run with --update to create it")
+			failed++
+			continue
+		}
+		if string(want) == got {
+			fmt.Println("ok   : " + name)
+			passed++
+			continue
+		}
+		fmt.Println("FAIL : " + name + " : output differs from " + t.golden())
+		fmt.Print(diffLines(strings.Split(string(want), "\n"), strings.Split(got, "\n")))
+		failed++
+	}
+	fmt.Printf("TESTS : %d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
+
+//	diffLines describes how to turn the lines in want into the lines in got.
+//	Every run of differing lines is introduced by its line number in want,
+//	followed by the lines taken out ("-") and the lines put in ("+").
+func diffLines(want, got []string) string {
+	//	lcs[i][j] is the length of the longest common subsequence of
+	//	want[i:] and got[j:]
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if want[i] == got[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	diff := ""
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		if i < len(want) && j < len(got) && want[i] == got[j] {
+			i, j = i+1, j+1
+			continue
+		}
+		diff += "    line " + strconv.Itoa(i+1) + ":\n"
+		for i < len(want) && (j == len(got) || lcs[i+1][j] >= lcs[i][j+1]) && !(j < len(got) && want[i] == got[j]) {
+			diff += "    - " + want[i] + "\n"
+			i++
+		}
+		for j < len(got) && !(i < len(want) && want[i] == got[j]) {
+			diff += "    + " + got[j] + "\n"
+			j++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,430 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"go/format"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	'bru build file.hdl --package name' translates every chip in an hdl file
+//	into an exported go function, in a package other go code can import:
+//
+//		* full_adder               func FullAdder(a, b, c string) (s, co string)
+//		IN a b c
+//		OUT s co
+//
+//	Wires carry "0", "1" or "X", and buffers are arrays of them, bit 0 first.
+//	Clocked chips with looped back inputs also get a state struct holding
+//	those inputs, whose Step method simulates one clock cycle:
+//
+//		* counter                  type CounterState struct { Q [4]string }
+//		CLK                        func NewCounterState() *CounterState
+//		IN en (q[4]|n[4])          func (s *CounterState) Step(en string) (n [4]string)
+//		OUT n[4]
+//
+//	Inside a function the chip's statements are put in an order in which
+//	every wire is assigned before it is read, so chips with feedback inside
+//	them, and chips using memories, can't be translated.
+
+//	packageCode returns a go package, with the given name, holding every chip
+//	read from hdlFile
+func packageCode(name, hdlFile string) (string, error) {
+	funcs := map[string]string{}
+	code := generatedHeader + "\n\n"
+	code += "// Package " + name + " holds the chips of " + filepath.Base(hdlFile) + ", translated into go by bru.\n"
+	code += "// Wires carry \"0\", \"1\" or \"X\", and buffers are arrays of them, bit 0 first.\n"
+	code += "package " + name + "\n"
+	for k := range chips {
+		c := &chips[k]
+		if strings.Contains(c.commands, "ram<") || strings.Contains(c.commands, "rom<") {
+			return "", newError(semanticError, "chip %s uses memories, which can only be simulated with 'bru run'.", c.name)
+		}
+		if _, err := buildNetlist(c, chips); err != nil {
+			return "", classify(semanticError, err)
+		}
+		f := exportedName(c.name)
+		if other, ok := funcs[f]; ok {
+			return "", newError(semanticError, "chips %s and %s would both be called %s", other, c.name, f)
+		}
+		funcs[f] = c.name
+		fun, err := chipFunction(c)
+		if err != nil {
+			return "", err
+		}
+		code += "\n" + fun
+		if c.clocked && len(c.loops) > 0 {
+			state, err := chipState(c)
+			if err != nil {
+				return "", err
+			}
+			code += "\n" + state
+		}
+	}
+	code += gateCode
+	tidy, err := format.Source([]byte(code))
+	if err != nil {
+		return "", newError(semanticError, "the chips of %s can't be translated into go: %v", hdlFile, err)
+	}
+	return string(tidy), nil
+}
+
+//	gateCode holds the gates every chip is made of
+const gateCode = `
+func not(i string) string {
+	switch i {
+	case "1":
+		return "0"
+	case "0":
+		return "1"
+	}
+	return "X"
+}
+
+func and(a, b string) string {
+	if a == "0" || b == "0" {
+		return "0"
+	} else if a == "1" && b == "1" {
+		return "1"
+	}
+	return "X"
+}
+
+func or(a, b string) string {
+	if a == "1" || b == "1" {
+		return "1"
+	} else if a == "0" && b == "0" {
+		return "0"
+	}
+	return "X"
+}
+`
+
+//	exportedName returns the name of the go function for a chip, eg.
+//	"FullAdder" for full_adder
+func exportedName(chip string) string {
+	name := ""
+	for _, v := range strings.Split(chip, "_") {
+		if v != "" {
+			name += strings.ToUpper(v[:1]) + v[1:]
+		}
+	}
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "Chip" + name
+	}
+	return name
+}
+
+//	goIdent returns the go name for a wire, renaming wires whose names go
+//	already uses
+func goIdent(wire string) string {
+	switch {
+	case token.Lookup(wire).IsKeyword(), wire == "and", wire == "or", wire == "not", wire == "string", wire == "_":
+		return wire + "_"
+	}
+	return wire
+}
+
+//	goType returns the go type of a wire of the given shape
+func goType(s hdl.Shape) string {
+	if s.Bus {
+		return "[" + strconv.Itoa(s.Width) + "]string"
+	}
+	return "string"
+}
+
+//	goParams returns a list of ports as go parameters, eg. "a [4]string, c string"
+func goParams(ports []hdl.Port) string {
+	var list []string
+	for _, p := range ports {
+		list = append(list, goIdent(p.Name)+" "+goType(p.Shape))
+	}
+	return strings.Join(list, ", ")
+}
+
+//	wireKey is a wire, or a single bit of a buffer, written or read by a
+//	statement
+type wireKey struct {
+	name string
+	bit  int // -1 for the whole wire
+}
+
+//	reads adds every wire read by an expression to keys
+func reads(e *hdl.Expr, keys []wireKey) []wireKey {
+	switch e.Kind {
+	case hdl.ExIdent:
+		return append(keys, wireKey{e.Name, -1})
+	case hdl.ExIndex:
+		return append(keys, wireKey{e.Name, e.Index})
+	case hdl.ExCall:
+		for _, a := range e.Args {
+			keys = reads(a, keys)
+		}
+	}
+	return keys
+}
+
+//	orderStatements puts the statements of a chip in an order in which every
+//	wire is written before it is read. Statements keep the order they were
+//	written in where they can.
+func orderStatements(c *chip, stmts []hdl.Stmt) ([]hdl.Stmt, error) {
+	//	writers of every wire, and of every bit of a buffer
+	writers := map[wireKey][]int{}
+	for k, s := range stmts {
+		for _, l := range s.Lhs {
+			bit := -1
+			if l.Kind == hdl.ExIndex {
+				bit = l.Index
+			}
+			writers[wireKey{l.Name, bit}] = append(writers[wireKey{l.Name, bit}], k)
+		}
+	}
+	needs := func(key wireKey) []int {
+		list := append([]int(nil), writers[wireKey{key.name, -1}]...)
+		for w, v := range writers {
+			if w.name == key.name && w.bit != -1 && (key.bit == -1 || key.bit == w.bit) {
+				list = append(list, v...)
+			}
+		}
+		return list
+	}
+	done := make([]bool, len(stmts))
+	var ordered []hdl.Stmt
+	for len(ordered) < len(stmts) {
+		progress := false
+		for k, s := range stmts {
+			if done[k] {
+				continue
+			}
+			ready := true
+			for _, key := range reads(s.Rhs, nil) {
+				for _, w := range needs(key) {
+					if !done[w] {
+						ready = false
+					}
+				}
+			}
+			if ready {
+				done[k] = true
+				ordered = append(ordered, s)
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			return nil, newError(semanticError, "chip %s has feedback inside it, which can't be translated into a function", c.name)
+		}
+	}
+	return ordered, nil
+}
+
+//	chipFunction translates a chip into an exported go function
+func chipFunction(c *chip) (string, error) {
+	h, err := elaborable(c)
+	if err != nil {
+		return "", err
+	}
+	ins, outs := h.Inputs, h.Outputs
+	stmts, err := orderStatements(c, h.Body)
+	if err != nil {
+		return "", err
+	}
+
+	//	every wire of the chip, with its shape
+	env := map[string]hdl.Shape{}
+	for _, p := range append(append([]hdl.Port(nil), ins...), outs...) {
+		env[p.Name] = p.Shape
+	}
+	var locals []string
+	for _, s := range stmts {
+		shapes := exprShapes(s.Rhs, env)
+		for k, l := range s.Lhs {
+			if _, found := env[l.Name]; !found && l.Kind == hdl.ExIdent && k < len(shapes) {
+				env[l.Name] = shapes[k]
+				locals = append(locals, l.Name)
+			}
+		}
+	}
+	read := map[string]bool{}
+	for _, s := range stmts {
+		for _, key := range reads(s.Rhs, nil) {
+			if _, found := env[key.name]; !found {
+				return "", newError(semanticError, "chip %s reads %s, which can't be translated into a function", c.name, key.name)
+			}
+			read[key.name] = true
+		}
+	}
+
+	fun := "// " + exportedName(c.name) + " computes the outputs of the chip " + c.name + ".\n"
+	fun += "func " + exportedName(c.name) + "(" + goParams(ins) + ") (" + goParams(outs) + ") {\n"
+	for _, v := range locals {
+		fun += "var " + goIdent(v) + " " + goType(env[v]) + "\n"
+	}
+	for _, s := range stmts {
+		var lhs []string
+		for _, l := range s.Lhs {
+			lhs = append(lhs, goExpr(l))
+		}
+		fun += strings.Join(lhs, ", ") + " = " + goExpr(s.Rhs) + "\n"
+	}
+	for _, v := range locals {
+		if !read[v] {
+			fun += "_ = " + goIdent(v) + "\n"
+		}
+	}
+	var results []string
+	for _, p := range outs {
+		results = append(results, goIdent(p.Name))
+	}
+	fun += "return " + strings.Join(results, ", ") + "\n}\n"
+	return fun, nil
+}
+
+//	exprShapes works out the shapes of the values an expression evaluates to
+func exprShapes(e *hdl.Expr, env map[string]hdl.Shape) []hdl.Shape {
+	switch e.Kind {
+	case hdl.ExIdent:
+		if s, found := env[e.Name]; found {
+			return []hdl.Shape{s}
+		}
+		return nil
+	case hdl.ExCall:
+		if c := findChip(e.Name); c != nil {
+			outs, _ := parsePorts(strings.Join(c.outputs, " "))
+			var shapes []hdl.Shape
+			for _, o := range outs {
+				shapes = append(shapes, o.Shape)
+			}
+			return shapes
+		}
+	}
+	return []hdl.Shape{{Width: 1}}
+}
+
+//	goExpr translates an expression into go
+func goExpr(e *hdl.Expr) string {
+	switch e.Kind {
+	case hdl.ExLit:
+		return strconv.Quote(e.Name)
+	case hdl.ExIdent:
+		return goIdent(e.Name)
+	case hdl.ExIndex:
+		return goIdent(e.Name) + "[" + strconv.Itoa(e.Index) + "]"
+	}
+	var args []string
+	for _, a := range e.Args {
+		args = append(args, goExpr(a))
+	}
+	name := e.Name
+	if !hdl.IsPrimitive(name) {
+		name = exportedName(name)
+	}
+	return name + "(" + strings.Join(args, ", ") + ")"
+}
+
+//	chipState translates a clocked chip into a state struct, holding its
+//	looped back inputs, with a Step method simulating one clock cycle
+func chipState(c *chip) (string, error) {
+	ins, _ := parsePorts(c.args)
+	outs, _ := parsePorts(strings.Join(c.outputs, " "))
+	shapes := map[string]hdl.Shape{}
+	for _, p := range append(append([]hdl.Port(nil), ins...), outs...) {
+		shapes[p.Name] = p.Shape
+	}
+	loops := c.loops
+	looped := map[string]string{}
+	for _, l := range loops {
+		if shapes[l[0]] != shapes[l[1]] {
+			return "", newError(semanticError, "chip %s loops %s back to %s, which is of a different size", c.name, l[1], l[0])
+		}
+		looped[l[0]] = l[1]
+	}
+
+	name := exportedName(c.name)
+	state := name + "State"
+	code := "// " + state + " holds the state of the chip " + c.name + " between clock cycles: the\n"
+	code += "// outputs looped back to its inputs.\n"
+	code += "type " + state + " struct {\n"
+	for _, l := range loops {
+		code += exportedName(l[0]) + " " + goType(shapes[l[0]]) + "\n"
+	}
+	code += "}\n\n"
+
+	code += "// New" + state + " returns the state of the chip " + c.name + " as it starts out.\n"
+	code += "func New" + state + "() *" + state + " {\n"
+	code += "return &" + state + "{"
+	var fields []string
+	for _, l := range loops {
+		v := strconv.Quote(startValue(c, l[0]))
+		if s := shapes[l[0]]; s.Bus {
+			v = goType(s) + "{" + strings.TrimSuffix(strings.Repeat(v+", ", s.Width), ", ") + "}"
+		}
+		fields = append(fields, exportedName(l[0])+": "+v)
+	}
+	code += strings.Join(fields, ", ") + "}\n}\n\n"
+
+	//	the receiver is named after none of the ports
+	recv := "s"
+	for _, p := range append(append([]hdl.Port(nil), ins...), outs...) {
+		if goIdent(p.Name) == recv {
+			recv = "state"
+		}
+	}
+	var stepIns []hdl.Port
+	var args []string
+	for _, p := range ins {
+		if _, ok := looped[p.Name]; ok {
+			args = append(args, recv+"."+exportedName(p.Name))
+		} else {
+			stepIns = append(stepIns, p)
+			args = append(args, goIdent(p.Name))
+		}
+	}
+	var results []string
+	for _, p := range outs {
+		results = append(results, goIdent(p.Name))
+	}
+	code += "// Step simulates one clock cycle of the chip " + c.name + ", feeding the outputs\n"
+	code += "// looped back to its inputs into the state.\n"
+	code += "func (" + recv + " *" + state + ") Step(" + goParams(stepIns) + ") (" + goParams(outs) + ") {\n"
+	code += strings.Join(results, ", ") + " = " + name + "(" + strings.Join(args, ", ") + ")\n"
+	for _, l := range loops {
+		code += recv + "." + exportedName(l[0]) + " = " + goIdent(l[1]) + "\n"
+	}
+	code += "return " + strings.Join(results, ", ") + "\n}\n"
+	return code, nil
+}
+
+//	startValue returns the value a wire of a chip starts with: the one given
+//	on the chip's INIT line if there is one, and "X" otherwise
+func startValue(c *chip, wire string) string {
+	if v, ok := c.init[wire]; ok {
+		return v
+	}
+	if c.initAll != "" {
+		return c.initAll
+	}
+	return "X"
+}
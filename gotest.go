@@ -0,0 +1,414 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	'bru build file.hdl script --package name --tests' translates scripts into
+//	go tests for the package 'bru build file.hdl --package name' writes. Every
+//	'call' of a combinational script becomes a test of its own, checking the
+//	outputs the way the expect and assert lines after it do:
+//
+//		func TestAdd4Call0(t *testing.T) {
+//			s, co := Add4([4]string{"1", "1", "0", "0"}, [4]string{"0", "0", "1", "0"}, "1")
+//			if s != [4]string{"0", "0", "0", "1"} {
+//				t.Errorf("add.scr:12 : expected s = %v, got %v", [4]string{"0", "0", "0", "1"}, s)
+//			}
+//			...
+//
+//	A clocked script becomes a single test stepping the chip through every
+//	cycle, with a subtest, eg. "t=3", for every cycle in which checks are made.
+//	Only the outputs of a chip, and its looped back inputs, can be checked.
+
+//	testCode returns a go test file, for the package with the given name,
+//	running the scripts against the functions of the package
+func testCode(pkg string, scriptFiles []string) (string, error) {
+	sims, err := planSimulations(scriptFiles)
+	if err != nil {
+		return "", err
+	}
+	code := generatedHeader + "\n\npackage " + pkg + "\n\nimport \"testing\"\n"
+	names := map[string]int{}
+	for _, s := range sims {
+		if _, err := chipFunction(s.top); err != nil {
+			return "", err
+		}
+		n, err := buildNetlist(s.top, chips)
+		if err != nil {
+			return "", classify(semanticError, err)
+		}
+		var tests string
+		if s.top.clocked {
+			tests, err = clockedTest(n, s, names)
+		} else {
+			tests, err = callTests(n, s, names)
+		}
+		if err != nil {
+			return "", classify(scriptError, fmt.Errorf("%s: %w", s.top.name, err))
+		}
+		code += tests
+	}
+	tidy, err := format.Source([]byte(code))
+	if err != nil {
+		return "", newError(scriptError, "the scripts can't be translated into go tests: %v", err)
+	}
+	return string(tidy), nil
+}
+
+//	testName returns a name for a test that no other test has
+func testName(names map[string]int, name string) string {
+	names[name]++
+	if names[name] > 1 {
+		name += strconv.Itoa(names[name])
+	}
+	return name
+}
+
+//	testIdent returns the go name for a wire inside a test, renaming wires
+//	whose names the test itself uses
+func testIdent(wire string) string {
+	switch wire {
+	case "t", "cycle", "state", "testing":
+		return wire + "_"
+	}
+	return goIdent(wire)
+}
+
+//	goValue returns the go value of a wire of the given shape
+func goValue(bits []string, s hdl.Shape) string {
+	if !s.Bus {
+		return strconv.Quote(bits[0])
+	}
+	var vals []string
+	for _, b := range bits {
+		vals = append(vals, strconv.Quote(b))
+	}
+	return goType(s) + "{" + strings.Join(vals, ", ") + "}"
+}
+
+//	portValue returns the go value of a port of the chip being simulated
+func (n *netlist) portValue(p hdl.Port) string {
+	nets, _ := n.lookup(p.Name)
+	var bits []string
+	for _, id := range nets {
+		bits = append(bits, n.Values[id])
+	}
+	return goValue(bits, p.Shape)
+}
+
+//	splitWire splits a wire into its name and the bit used, eg. "s[2]" into
+//	"s" and 2. The bit is -1 when the whole wire is used.
+func splitWire(name string) (string, int) {
+	i := strings.Index(name, "[")
+	if i == -1 {
+		return name, -1
+	}
+	k, _ := strconv.Atoi(strings.TrimSuffix(name[i+1:], "]"))
+	return name[:i], k
+}
+
+//	expected returns the go value a check expects a wire to have
+func (n *netlist) expected(c check) (string, error) {
+	nets, err := n.lookup(c.name)
+	if err != nil {
+		return "", err
+	}
+	var bits []string
+	if strings.HasPrefix(c.value, "[") {
+		bits = strings.Fields(strings.Trim(c.value, "[]"))
+	} else if bits, err = hdl.ParseNumber(c.value, len(nets)); err != nil {
+		return "", fmt.Errorf("%s : %s: %v", c.line, c.name, err)
+	}
+	if len(bits) != len(nets) {
+		return "", fmt.Errorf("%s : '%s' is %d bits wide, %d bits given", c.line, c.name, len(nets), len(bits))
+	}
+	_, single := n.Index[c.name]
+	return goValue(bits, hdl.Shape{Width: len(bits), Bus: !single}), nil
+}
+
+//	checkCode returns the go code making a check. got is the go expression
+//	holding the value of the wire checked.
+func (n *netlist) checkCode(c check, got string) (string, error) {
+	want, err := n.expected(c)
+	if err != nil {
+		return "", err
+	}
+	msg := strconv.Quote(c.line + " : expected " + c.name + " = %v, got %v")
+	return "if " + got + " != " + want + " {\nt.Errorf(" + msg + ", " + want + ", " + got + ")\n}\n", nil
+}
+
+//	skipCheck warns about a check that can't be made by a go test
+func skipCheck(c check) {
+	fmt.Fprintln(messages, "WARNING : "+c.line+" : only outputs and looped back inputs can be checked by go tests, leaving out the check of "+c.name)
+}
+
+//	callTests translates a script for a combinational chip into a test for
+//	every call it makes
+func callTests(n *netlist, sim simulation, names map[string]int) (string, error) {
+	name := exportedName(n.top.name)
+	type call struct {
+		args   []string
+		checks []check
+	}
+	var calls []call
+	for k, v := range returnLines(sim.script) {
+		switch {
+		case v == "" || strings.HasPrefix(v, "//") || isProbe(v) || isRadixLine(v):
+		case isControl(v):
+			return "", newError(scriptError, "%s : loops, variables, procedures, conditionals and 'step' can't be translated into go tests", sim.where(k))
+		case isTruthTable(v) || isVectors(v):
+			fmt.Fprintln(messages, "WARNING : "+sim.where(k)+" : truth tables and test vectors are left out of go tests")
+		case isCheck(v):
+			c, err := parseCheck(v, sim.where(k))
+			if err != nil {
+				return "", err
+			}
+			if len(calls) == 0 {
+				return "", fmt.Errorf("%s : checks must follow a 'call'", sim.where(k))
+			}
+			calls[len(calls)-1].checks = append(calls[len(calls)-1].checks, c)
+		case v == "call":
+			var args []string
+			for _, p := range n.ins {
+				args = append(args, n.portValue(p))
+			}
+			calls = append(calls, call{args: args})
+		case strings.Contains(v, "="):
+			a, err := parseAssignment(v)
+			if err != nil {
+				return "", fmt.Errorf("%s : %v", sim.where(k), err)
+			}
+			if base, _ := splitWire(a.name); base == "reset" && !n.isInput("reset") {
+				return "", fmt.Errorf("%s : go tests can't set reset", sim.where(k))
+			}
+			if err := n.apply([]assignment{a}); err != nil {
+				return "", fmt.Errorf("%s : %v", sim.where(k), err)
+			}
+		}
+	}
+
+	code := ""
+	for k, c := range calls {
+		checked := map[string]bool{}
+		body := ""
+		for _, ch := range c.checks {
+			ch.name = strings.TrimPrefix(ch.name, n.top.name+".")
+			base, bit := splitWire(ch.name)
+			if !n.isOutput(base) {
+				skipCheck(ch)
+				continue
+			}
+			got := testIdent(base)
+			if bit != -1 {
+				got += "[" + strconv.Itoa(bit) + "]"
+			}
+			check, err := n.checkCode(ch, got)
+			if err != nil {
+				return "", err
+			}
+			body += check
+			checked[base] = true
+		}
+		var results []string
+		assign := " = "
+		for _, p := range n.outs {
+			if checked[p.Name] {
+				results = append(results, testIdent(p.Name))
+				assign = " := "
+			} else {
+				results = append(results, "_")
+			}
+		}
+		test := testName(names, "Test"+name+"Call"+strconv.Itoa(k))
+		code += "\n// " + test + " runs call " + strconv.Itoa(k) + " of " + sim.file + " against " + name + ".\n"
+		code += "func " + test + "(t *testing.T) {\n"
+		code += strings.Join(results, ", ") + assign + name + "(" + strings.Join(c.args, ", ") + ")\n"
+		code += body + "}\n"
+	}
+	return code, nil
+}
+
+//	clockedTest translates a script for a clocked chip into a test stepping
+//	the chip through every cycle
+func clockedTest(n *netlist, sim simulation, names map[string]int) (string, error) {
+	for _, v := range returnLines(sim.script) {
+		if isControl(v) {
+			return "", newError(scriptError, "loops, variables, procedures, conditionals and 'step' can't be translated into go tests")
+		}
+	}
+	s, err := parseClockedScript(sim)
+	if err != nil {
+		return "", err
+	}
+	if s.vectors != "" {
+		return "", newError(scriptError, "test vectors can't be translated into go tests")
+	}
+	if s.dur < 1 {
+		return "", newError(scriptError, "'dur' is 0, so there is nothing to test")
+	}
+
+	name := exportedName(n.top.name)
+	loops := n.top.loops
+	looped := map[string]bool{}
+	for _, l := range loops {
+		looped[l[0]] = true
+	}
+	//	wire returns the go expression for an input or output of the chip
+	wire := func(base string, bit int) string {
+		w := testIdent(base)
+		if looped[base] {
+			w = "state." + exportedName(base)
+		}
+		if bit != -1 {
+			w += "[" + strconv.Itoa(bit) + "]"
+		}
+		return w
+	}
+	//	assign applies assignments, returning the go code making them
+	assign := func(as []assignment) (string, error) {
+		code := ""
+		for _, a := range as {
+			base, bit := splitWire(a.name)
+			if base == "reset" && !n.isInput("reset") {
+				return "", fmt.Errorf("go tests can't set reset")
+			}
+			if err := n.apply([]assignment{a}); err != nil {
+				return "", err
+			}
+			for _, p := range n.ins {
+				if p.Name != base {
+					continue
+				}
+				v := n.portValue(p)
+				if bit != -1 {
+					nets, _ := n.lookup(a.name)
+					v = strconv.Quote(n.Values[nets[0]])
+				}
+				code += wire(base, bit) + " = " + v + "\n"
+			}
+		}
+		return code, nil
+	}
+
+	test := testName(names, "Test"+name)
+	code := "\n// " + test + " runs " + sim.file + " against " + name + ", one cycle at a time.\n"
+	code += "func " + test + "(t *testing.T) {\n"
+	var args []string
+	if len(loops) > 0 {
+		code += "state := New" + name + "State()\n"
+	}
+	for _, p := range n.ins {
+		if looped[p.Name] {
+			args = append(args, wire(p.Name, -1))
+			continue
+		}
+		code += testIdent(p.Name) + " := " + n.portValue(p) + "\n"
+		args = append(args, testIdent(p.Name))
+	}
+	var results []string
+	for _, p := range n.outs {
+		code += "var " + testIdent(p.Name) + " " + goType(p.Shape) + "\n"
+		results = append(results, testIdent(p.Name))
+	}
+	init, err := assign(s.init)
+	if err != nil {
+		return "", err
+	}
+	code += init
+	step := strings.Join(results, ", ") + " = " + name + "(" + strings.Join(args, ", ") + ")\n"
+	if len(loops) > 0 {
+		step = strings.Join(results, ", ") + " = state.Step(" + strings.Join(nonLooped(args, n.ins, looped), ", ") + ")\n"
+	}
+
+	checked := map[string]bool{}
+	for t := 0; t < s.dur; {
+		//	cycles in which nothing but the assignments made every
+		//	cycle happen are run in a loop
+		quiet := 0
+		for t+quiet < s.dur && len(s.checks[t+quiet]) == 0 && len(s.blocks[t+quiet]) == 0 {
+			quiet++
+		}
+		if quiet > 1 {
+			always, err := assign(s.always)
+			if err != nil {
+				return "", err
+			}
+			code += "for cycle := 0; cycle < " + strconv.Itoa(quiet) + "; cycle++ {\n" + step + always + "}\n"
+			t += quiet
+			continue
+		}
+		code += step
+		if len(s.checks[t]) > 0 {
+			body := ""
+			for _, c := range s.checks[t] {
+				c.name = strings.TrimPrefix(c.name, n.top.name+".")
+				base, bit := splitWire(c.name)
+				if !n.isOutput(base) && !looped[base] {
+					skipCheck(c)
+					continue
+				}
+				check, err := n.checkCode(c, wire(base, bit))
+				if err != nil {
+					return "", err
+				}
+				body += check
+				checked[base] = true
+			}
+			if body != "" {
+				code += "t.Run(\"t=" + strconv.Itoa(t) + "\", func(t *testing.T) {\n" + body + "})\n"
+			}
+		}
+		always, err := assign(s.always)
+		if err != nil {
+			return "", err
+		}
+		block, err := assign(s.blocks[t])
+		if err != nil {
+			return "", err
+		}
+		code += always + block
+		t++
+	}
+	for _, p := range n.outs {
+		if !checked[p.Name] {
+			code += "_ = " + testIdent(p.Name) + "\n"
+		}
+	}
+	return code + "}\n", nil
+}
+
+//	nonLooped returns the arguments for the inputs of a chip that aren't
+//	looped back
+func nonLooped(args []string, ins []hdl.Port, looped map[string]bool) []string {
+	var list []string
+	for k, p := range ins {
+		if !looped[p.Name] {
+			list = append(list, args[k])
+		}
+	}
+	return list
+}
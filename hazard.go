@@ -0,0 +1,99 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+//	A hazard is a net that changes more often than it has to after the inputs
+//	change. If its value ends up the same as it was, but it changed on the way
+//	there, that is a static hazard (static-0 or static-1, after the value it
+//	should have held). If its value ends up different, but it changed more than
+//	once on the way there, that is a dynamic hazard.
+
+//	hazard describes a single hazard found by the timed simulator
+type hazard struct {
+	kind     string   // "static-0", "static-1" or "dynamic"
+	net      string   // name of the net within its instance
+	instance string   // path of the chip instance the net belongs to
+	trigger  string   // the input changes that caused the hazard
+	values   []string // values taken by the net, in order
+}
+
+//	record notes the value a net takes, when looking for hazards
+func (s *timedSim) record(net int, value string) {
+	if s.history == nil {
+		return
+	}
+	if s.history[net] == nil {
+		s.history[net] = []string{s.n.Values[net]}
+	}
+	s.history[net] = append(s.history[net], value)
+}
+
+//	findHazards looks through the values taken by the nets during the last
+//	run for hazards. Nets that did not have a known value before the run are
+//	left out.
+func (s *timedSim) findHazards() []hazard {
+	var nets []int
+	for k := range s.history {
+		nets = append(nets, k)
+	}
+	sort.Ints(nets)
+	var found []hazard
+	for _, k := range nets {
+		vals := s.history[k]
+		first, last := vals[0], vals[len(vals)-1]
+		if first == "X" {
+			continue
+		}
+		h := hazard{trigger: strings.Join(s.trigger, ", "), values: vals}
+		switch {
+		case first == last && len(vals) > 2:
+			h.kind = "static-" + first
+		case first != last && len(vals) > 3:
+			h.kind = "dynamic"
+		default:
+			continue
+		}
+		name := s.n.Names[k][0]
+		h.instance, h.net = s.n.top.name, name
+		if i := strings.LastIndex(name, "."); i != -1 {
+			h.instance, h.net = s.n.top.name+"."+name[:i], name[i+1:]
+		}
+		found = append(found, h)
+	}
+	return found
+}
+
+//	reportHazards prints the hazards found during the last evaluation
+func (n *netlist) reportHazards(out io.Writer) {
+	if n.timed == nil {
+		return
+	}
+	for _, h := range n.timed.hazards {
+		fmt.Fprintf(out, "HAZARD : %s hazard on %s in %s, after %s : %s\n",
+			h.kind, h.net, h.instance, h.trigger, strings.Join(h.values, " -> "))
+	}
+}
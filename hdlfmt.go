@@ -0,0 +1,129 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	'bru fmt' lays out hdl files the standard way:
+//
+//		* full_adder
+//		SIM
+//		IN a b c
+//		OUT s co
+//		CON
+//		    t = xor(a, b)
+//		    s, co = half(t, c)
+//		END
+//
+//	Chips are separated by a single blank line, words in flag, IN and OUT
+//	lines by a single space, and the lines between CON and END are indented
+//	by four spaces, with a space after every comma and around '='. Lines the
+//	formatter doesn't understand are only trimmed.
+
+//	formatHDL returns the contents of an hdl file laid out the standard way
+func formatHDL(src string) string {
+	var out []string
+	blank := func() {
+		if len(out) > 0 && out[len(out)-1] != "" {
+			out = append(out, "")
+		}
+	}
+	inCon, inLoad := false, false
+	for _, v := range returnLines(src) {
+		switch {
+		case inLoad:
+			//	files to load are never indented, as they are read
+			//	exactly as written
+			name := strings.TrimSpace(strings.TrimSuffix(v, "]"))
+			if name != "" {
+				out = append(out, name)
+			}
+			if strings.HasSuffix(v, "]") {
+				out = append(out, "]")
+				inLoad = false
+			}
+		case v == "":
+			blank()
+		case inCon && v == "END":
+			out = append(out, "END")
+			inCon = false
+		case inCon:
+			out = append(out, "    "+formatStatement(v))
+		case strings.HasPrefix(v, "LOAD"):
+			out = append(out, "LOAD [")
+			inLoad = true
+			rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(v[4:]), "["))
+			if name := strings.TrimSpace(strings.TrimSuffix(rest, "]")); name != "" {
+				out = append(out, name)
+			}
+			if strings.HasSuffix(rest, "]") {
+				out = append(out, "]")
+				inLoad = false
+			}
+		case strings.HasPrefix(v, "*"):
+			blank()
+			out = append(out, "* "+strings.TrimSpace(v[1:]))
+		case v == "CON":
+			out = append(out, v)
+			inCon = true
+		case strings.HasPrefix(v, "//"):
+			out = append(out, v)
+		default:
+			out = append(out, strings.Join(strings.Fields(v), " "))
+		}
+	}
+	for len(out) > 0 && out[len(out)-1] == "" {
+		out = out[:len(out)-1]
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+//	formatStatement lays out a line of a CON block, eg. "s,co=half( t,c )"
+//	becomes "s, co = half(t, c)"
+func formatStatement(line string) string {
+	if strings.HasPrefix(line, "//") {
+		return line
+	}
+	toks, err := hdl.Tokenize(line)
+	if err != nil {
+		return line
+	}
+	s, word := "", false
+	for _, t := range toks {
+		isWord := strings.IndexByte("()[],=<>", t[0]) == -1
+		switch {
+		case t == ",":
+			s += ", "
+		case t == "=":
+			s = strings.TrimRight(s, " ") + " = "
+		case isWord && word:
+			//	keep words apart, even where they don't belong
+			s += " " + t
+		default:
+			s += t
+		}
+		word = isWord
+	}
+	return strings.TrimSpace(s)
+}
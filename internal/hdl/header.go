@@ -0,0 +1,143 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package hdl
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//	Header is what the lines of a chip before its CON block declare about it
+type Header struct {
+	Name     string
+	Inputs   []Port
+	Outputs  []Port
+	Simulate bool              // marked with SIM
+	Clocked  bool              // marked with CLK
+	Delay    int               // propagation delay given with DELAY, if any
+	Loops    [][2]string       // names of looped back (input, output) pairs, written (in|out)
+	InitAll  string            // value all wires of the chip start with, if given
+	Init     map[string]string // values particular wires of the chip start with
+}
+
+//	Read reads a line of a chip that comes before its CON block, eg.
+//	"IN a b[4]" or "INIT q=0", into the header
+func (h *Header) Read(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch fields[0] {
+	case "SIM":
+		h.Simulate = true
+	case "CLK":
+		h.Clocked = true
+	case "CON":
+	case "DELAY":
+		d, err := strconv.Atoi(strings.Join(fields[1:], " "))
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid delay for chip %s", h.Name)
+		}
+		h.Delay = d
+	case "INIT":
+		return h.readInit(fields[1:])
+	case "IN":
+		if len(fields) == 1 {
+			return fmt.Errorf("no inputs given for chip %s", h.Name)
+		}
+		for _, v := range fields[1:] {
+			loop := ""
+			if strings.ContainsAny(v, "(|)") {
+				if !IsLoop(v) {
+					return fmt.Errorf("malformed looped back input '%s' in chip %s, expected (in|out)", v, h.Name)
+				}
+				v, loop = v[1:strings.Index(v, "|")], v[strings.Index(v, "|")+1:len(v)-1]
+			}
+			p, err := ParsePort(v)
+			if err != nil {
+				return fmt.Errorf("%s: %v", h.Name, err)
+			}
+			h.Inputs = append(h.Inputs, p)
+			if loop != "" {
+				out, err := ParsePort(loop)
+				if err != nil {
+					return fmt.Errorf("%s: %v", h.Name, err)
+				}
+				h.Loops = append(h.Loops, [2]string{p.Name, out.Name})
+			}
+		}
+	case "OUT":
+		if len(fields) == 1 {
+			return fmt.Errorf("no outputs given for chip %s", h.Name)
+		}
+		for _, v := range fields[1:] {
+			p, err := ParsePort(v)
+			if err != nil {
+				return fmt.Errorf("%s: %v", h.Name, err)
+			}
+			h.Outputs = append(h.Outputs, p)
+		}
+	default:
+		return fmt.Errorf("unknown keyword '%s' in chip %s", fields[0], h.Name)
+	}
+	return nil
+}
+
+//	readInit reads the values given on the INIT line of a chip: a value for
+//	all of its wires, eg. "0", and values for particular wires, eg. "q=1"
+func (h *Header) readInit(fields []string) error {
+	for _, v := range fields {
+		i := strings.Index(v, "=")
+		if i == -1 {
+			if !IsBit(v) {
+				return fmt.Errorf("invalid starting value '%s' for chip %s", v, h.Name)
+			}
+			h.InitAll = v
+			continue
+		}
+		if i == 0 || !IsBit(v[i+1:]) {
+			return fmt.Errorf("invalid starting value '%s' for chip %s", v, h.Name)
+		}
+		if h.Init == nil {
+			h.Init = map[string]string{}
+		}
+		h.Init[v[:i]] = v[i+1:]
+	}
+	return nil
+}
+
+//	IsLoop reports whether an input is a looped back output, written (in|out)
+func IsLoop(v string) bool {
+	i := strings.Index(v, "|")
+	return strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")") && i > 1 && i < len(v)-2 && strings.Count(v, "|") == 1
+}
+
+//	RelativeTo resolves the name of a file mentioned in another file, ref,
+//	against the folder ref is in. Files loaded with LOAD, the contents of
+//	memories and test vectors are all found this way. Names mentioned in
+//	no file in particular (ref is empty) are left as they are.
+func RelativeTo(ref, name string) string {
+	if ref == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(ref), name)
+}
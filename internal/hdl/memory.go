@@ -0,0 +1,190 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+
+package hdl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+//	Memories are built in, like the and, or and not gates. They are used as
+//
+//		data = ram<WORDS, WIDTH>(address, in, write)
+//		data = rom<WORDS, WIDTH, "contents.hex">(address)
+//
+//	and always output the word at the given address. While 'write' is 1, a
+//	ram stores 'in' at the given address. Bit 0 of a buffer is its least
+//	significant bit. An address that is unknown or out of range reads as X,
+//	and nothing is written to it. The file holding the contents of a memory
+//	is looked for in the directory of the HDL file the memory is used in.
+
+//	Memory is a ram or rom in a netlist, as it starts out. The contents it
+//	has while being simulated are kept in a State.
+type Memory struct {
+	Name     string     // path of the memory in the netlist, eg. "cpu0.ram0"
+	Words    int        // number of words stored
+	Width    int        // number of bits in a word
+	AddrBits int        // number of bits in the address
+	Data     [][]string // the words it starts with, bit 0 first, "" if not given
+}
+
+//	IsMemory reports whether name is one of the built in memories
+func IsMemory(name string) bool {
+	return name == "ram" || name == "rom"
+}
+
+//	memoryParams reads the parameters of a memory, given as <WORDS, WIDTH>
+//	with the name of a file holding the initial contents as an optional third
+//	parameter. A rom must be given this file.
+func memoryParams(e *Expr) (words, width int, file string, err error) {
+	if len(e.Params) < 2 || len(e.Params) > 3 {
+		return 0, 0, "", fmt.Errorf("'%s' needs to be given as %s<WORDS, WIDTH>", e.Name, e.Name)
+	}
+	words, err = strconv.Atoi(e.Params[0])
+	if err != nil || words < 1 {
+		return 0, 0, "", fmt.Errorf("invalid number of words for '%s'", e.Name)
+	}
+	width, err = strconv.Atoi(e.Params[1])
+	if err != nil || width < 1 {
+		return 0, 0, "", fmt.Errorf("invalid width for '%s'", e.Name)
+	}
+	if len(e.Params) == 3 {
+		file = strings.Trim(e.Params[2], "\"")
+	} else if e.Name == "rom" {
+		return 0, 0, "", errors.New("no contents given for 'rom'")
+	}
+	return words, width, file, nil
+}
+
+//	buildMemory adds a ram or rom, used in chip c, to the netlist
+func (b *builder) buildMemory(sc *scope, c *Chip, e *Expr, args []signal, targets []signal) ([]signal, error) {
+	words, width, file, err := memoryParams(e)
+	if err != nil {
+		return nil, err
+	}
+	want := 1
+	if e.Name == "ram" {
+		want = 3
+	}
+	if len(args) != want {
+		return nil, fmt.Errorf("'%s' takes %d inputs, %d given", e.Name, want, len(args))
+	}
+	if e.Name == "ram" {
+		if args[1].shape() != (Shape{width, true}) {
+			return nil, fmt.Errorf("data written to 'ram' must be a buffer of %d bits", width)
+		}
+		if args[2].bus {
+			return nil, errors.New("write enable of 'ram' must be a single bit")
+		}
+	}
+	el := Element{Op: e.Name, Path: sc.instance(e.Name), Delay: b.delay(e.Name), Mem: len(b.n.Mems)}
+	m := &Memory{Name: el.Path, Words: words, Width: width, AddrBits: len(args[0].nets)}
+	for k := 0; k < words; k++ {
+		m.Data = append(m.Data, make([]string, width))
+	}
+	if file != "" {
+		if err := m.load(RelativeTo(c.File, file)); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range args {
+		el.Ins = append(el.Ins, a.nets...)
+	}
+	out := signal{}
+	if targets != nil {
+		out = targets[0]
+	} else {
+		out = b.newSignal(m.Name, Shape{width, true})
+	}
+	el.Outs = out.nets
+	b.n.Elems = append(b.n.Elems, el)
+	b.n.Mems = append(b.n.Mems, m)
+	return []signal{out}, nil
+}
+
+//	load reads the contents of a memory from a file. Files ending in ".bin"
+//	hold one binary number per word, all others one hexadecimal number per
+//	word. Words are separated by spaces or new lines, '_' may be used to
+//	group digits, X digits are unknown bits and '//' starts a comment.
+func (m *Memory) load(filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	digitBits := 4
+	if strings.HasSuffix(filename, ".bin") {
+		digitBits = 1
+	}
+	addr := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		if i := strings.Index(line, "//"); i != -1 {
+			line = line[:i]
+		}
+		for _, w := range strings.Fields(line) {
+			if addr >= m.Words {
+				return fmt.Errorf("%s: more than %d words", filename, m.Words)
+			}
+			bits, err := digitsToBits(strings.ReplaceAll(w, "_", ""), digitBits)
+			if err != nil {
+				return fmt.Errorf("%s: %v", filename, err)
+			}
+			for k := range m.Data[addr] {
+				m.Data[addr][k] = "0"
+				if k < len(bits) {
+					m.Data[addr][k] = bits[k]
+				}
+			}
+			for k := m.Width; k < len(bits); k++ {
+				if bits[k] != "0" {
+					return fmt.Errorf("%s: '%s' does not fit in %d bits", filename, w, m.Width)
+				}
+			}
+			addr++
+		}
+	}
+	return nil
+}
+
+//	digitsToBits turns a binary (1 bit per digit) or hexadecimal (4 bits per
+//	digit) number into its bits, least significant first
+func digitsToBits(word string, digitBits int) ([]string, error) {
+	var bits []string
+	for k := len(word) - 1; k >= 0; k-- {
+		c := word[k]
+		if c == 'X' || c == 'x' {
+			for b := 0; b < digitBits; b++ {
+				bits = append(bits, "X")
+			}
+			continue
+		}
+		v, err := strconv.ParseUint(string(c), 1<<uint(digitBits), 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid word '%s'", word)
+		}
+		for b := 0; b < digitBits; b++ {
+			bits = append(bits, strconv.Itoa(int(v>>uint(b)&1)))
+		}
+	}
+	return bits, nil
+}
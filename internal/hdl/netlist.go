@@ -0,0 +1,515 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+
+package hdl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//	Elaborating a chip flattens it, and every chip it uses, into a netlist of
+//	primitive gates connected by single bit nets. Every wire of the chip, and
+//	of the chips inside it, is known by its hierarchical name, eg. "fa0.t" for
+//	the wire t of the first full adder used, and every bit of a buffer by its
+//	index, eg. "s[2]".
+
+//	Chip is a chip ready to be elaborated
+type Chip struct {
+	Name    string
+	Inputs  []Port
+	Outputs []Port
+	Body    []Stmt            // the assignments in its CON block
+	Delay   int               // propagation delay of the chip as a whole, if any
+	InitAll string            // value all wires of the chip start with, if given
+	Init    map[string]string // values particular wires of the chip start with
+	File    string            // file the chip was read from, if any
+}
+
+//	Element is a single primitive gate in a netlist
+type Element struct {
+	Op    string // and, or, not, buf, const, ram or rom
+	Ins   []int  // nets read by the element
+	Outs  []int  // nets driven by the element
+	Val   string // value driven by a const element
+	Path  string // hierarchical name of the element, eg. "srlatch0.nand1"
+	Delay int    // time taken for a change at an input to reach the outputs
+	Mem   int    // memory accessed by a ram or rom element
+}
+
+//	Netlist is a chip elaborated down to primitive gates
+type Netlist struct {
+	Top   *Chip
+	Names [][]string     // every name a net is known by
+	Index map[string]int // hierarchical name -> net
+	Elems []Element      // gates, in the order in which they are evaluated
+	Mems  []*Memory      // every ram and rom in the netlist
+	Init  []string       // starting value of every net, if it has its own
+	Reset int            // the net carrying the global reset signal
+}
+
+//	signal is a value as seen by the HDL: the nets carrying its bits
+type signal struct {
+	nets []int
+	bus  bool
+}
+
+func (s signal) shape() Shape {
+	return Shape{len(s.nets), s.bus}
+}
+
+//	Build elaborates the top chip, and every chip it uses, into a netlist.
+//	chips looks up the chips that may be used by name; it returns nil if
+//	there is no such chip. delays holds the delay of every kind of primitive
+//	gate and memory; if it is nil, the delays of chips are left out too.
+func Build(top *Chip, chips func(name string) (*Chip, error), delays map[string]int) (*Netlist, error) {
+	b := &builder{
+		n:      &Netlist{Top: top, Index: map[string]int{}},
+		chips:  chips,
+		delays: delays,
+		active: map[string]bool{},
+	}
+	var ins []signal
+	for _, p := range top.Inputs {
+		ins = append(ins, b.newSignal(p.Name, p.Shape))
+	}
+	if id, ok := b.n.Index["reset"]; ok {
+		b.n.Reset = id
+	} else {
+		b.n.Reset = b.newNet("reset")
+	}
+	if _, err := b.elaborate(top, "", ins, nil); err != nil {
+		return nil, err
+	}
+	return b.n, nil
+}
+
+//	builder holds what is needed while a netlist is elaborated
+type builder struct {
+	n      *Netlist
+	chips  func(name string) (*Chip, error)
+	delays map[string]int
+	active map[string]bool // chips being elaborated, to catch recursion
+	inside int             // depth of chips with their own delay being elaborated
+}
+
+//	scope holds what is needed while elaborating one instance of a chip
+type scope struct {
+	path  string            // prefix for the names of nets in this instance
+	env   map[string]signal // wires visible inside the chip
+	count map[string]int    // number of instances of every chip so far
+}
+
+//	instance returns the name for the next instance of the named chip
+func (s *scope) instance(name string) string {
+	k := s.count[name]
+	s.count[name]++
+	return s.path + name + strconv.Itoa(k)
+}
+
+//	chip looks up a chip used by another
+func (b *builder) chip(name string) (*Chip, error) {
+	c, err := b.chips(name)
+	if err == nil && c == nil {
+		err = fmt.Errorf("unknown chip '%s'", name)
+	}
+	return c, err
+}
+
+//	newNet adds a net to the netlist, known by the given name
+func (b *builder) newNet(name string) int {
+	id := len(b.n.Names)
+	b.n.Init = append(b.n.Init, "")
+	b.n.Names = append(b.n.Names, nil)
+	b.alias(id, name)
+	return id
+}
+
+//	alias lets a net also be known by another name
+func (b *builder) alias(id int, name string) {
+	if _, ok := b.n.Index[name]; ok {
+		return
+	}
+	b.n.Index[name] = id
+	b.n.Names[id] = append(b.n.Names[id], name)
+}
+
+//	newSignal allocates the nets for a wire of the given shape
+func (b *builder) newSignal(name string, s Shape) signal {
+	if !s.Bus {
+		return signal{nets: []int{b.newNet(name)}}
+	}
+	sig := signal{bus: true}
+	for k := 0; k < s.Width; k++ {
+		sig.nets = append(sig.nets, b.newNet(name+"["+strconv.Itoa(k)+"]"))
+	}
+	return sig
+}
+
+//	aliasSignal names the nets of an existing wire
+func (b *builder) aliasSignal(name string, sig signal) {
+	if !sig.bus {
+		b.alias(sig.nets[0], name)
+		return
+	}
+	for k, id := range sig.nets {
+		b.alias(id, name+"["+strconv.Itoa(k)+"]")
+	}
+}
+
+//	elaborate adds the gates making up one instance of chip c to the netlist.
+//	ins holds the signals connected to the inputs of the chip. outs holds the
+//	signals the outputs of the chip should drive; when it is nil, new wires
+//	are created for them. The signals driven by the outputs are returned.
+func (b *builder) elaborate(c *Chip, path string, ins []signal, outs []signal) ([]signal, error) {
+	if b.active[c.Name] {
+		return nil, fmt.Errorf("chip '%s' contains itself", c.Name)
+	}
+	b.active[c.Name] = true
+	defer delete(b.active, c.Name)
+
+	if len(ins) != len(c.Inputs) {
+		return nil, fmt.Errorf("chip '%s' takes %d inputs, %d given", c.Name, len(c.Inputs), len(ins))
+	}
+	if outs != nil && len(outs) != len(c.Outputs) {
+		return nil, fmt.Errorf("chip '%s' has %d outputs, %d expected", c.Name, len(c.Outputs), len(outs))
+	}
+	sc := &scope{path: path, env: map[string]signal{}, count: map[string]int{}}
+	first, firstMem := len(b.n.Names), len(b.n.Mems)
+	for k, p := range c.Inputs {
+		if ins[k].shape() != p.Shape {
+			return nil, fmt.Errorf("input '%s' of chip '%s' connected to a wire of the wrong size", p.Name, c.Name)
+		}
+		sc.env[p.Name] = ins[k]
+		b.aliasSignal(path+p.Name, ins[k])
+	}
+	for k, p := range c.Outputs {
+		if outs != nil {
+			if outs[k].shape() != p.Shape {
+				return nil, fmt.Errorf("output '%s' of chip '%s' connected to a wire of the wrong size", p.Name, c.Name)
+			}
+			sc.env[p.Name] = outs[k]
+			b.aliasSignal(path+p.Name, outs[k])
+		} else if p.Bus {
+			sc.env[p.Name] = b.newSignal(path+p.Name, p.Shape)
+		}
+	}
+
+	//	chips can read the global reset signal, unless they have a wire of
+	//	their own called reset
+	if _, found := sc.env["reset"]; !found && !assigns(c.Body, "reset") {
+		sc.env["reset"] = signal{nets: []int{b.n.Reset}}
+	}
+
+	//	every wire assigned in the chip is created before any statement is
+	//	elaborated, so that wires may be used before they are assigned.
+	for pending := true; pending; {
+		pending = false
+		progress := false
+		for _, s := range c.Body {
+			shapes, ok, err := b.resultShapes(sc, s.Rhs)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", s.Where, err)
+			}
+			for k, l := range s.Lhs {
+				if _, found := sc.env[l.Name]; found || l.Kind != ExIdent {
+					continue
+				}
+				if !ok {
+					pending = true
+					continue
+				}
+				if len(shapes) != len(s.Lhs) {
+					return nil, fmt.Errorf("%s: %d values assigned to %d wires", s.Where, len(shapes), len(s.Lhs))
+				}
+				sc.env[l.Name] = b.newSignal(path+l.Name, shapes[k])
+				progress = true
+			}
+		}
+		if pending && !progress {
+			return nil, fmt.Errorf("%s: wires are only assigned from each other", c.Name)
+		}
+	}
+
+	for _, s := range c.Body {
+		var targets []signal
+		for _, l := range s.Lhs {
+			t, err := sc.target(l)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", s.Where, err)
+			}
+			targets = append(targets, t)
+		}
+		if _, err := b.build(sc, c, s.Rhs, targets); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Where, err)
+		}
+	}
+
+	if err := b.applyInit(c, sc, first, firstMem); err != nil {
+		return nil, err
+	}
+
+	var result []signal
+	for _, p := range c.Outputs {
+		sig, found := sc.env[p.Name]
+		if !found {
+			return nil, fmt.Errorf("output '%s' of chip '%s' is never assigned", p.Name, c.Name)
+		}
+		result = append(result, sig)
+	}
+	return result, nil
+}
+
+//	assigns reports whether any of the statements assigns to the named wire
+func assigns(stmts []Stmt, name string) bool {
+	for _, s := range stmts {
+		for _, l := range s.Lhs {
+			if l.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//	target returns the signal an assignment writes to
+func (sc *scope) target(l *Expr) (signal, error) {
+	sig, found := sc.env[l.Name]
+	if !found {
+		return signal{}, fmt.Errorf("unknown wire '%s'", l.Name)
+	}
+	if l.Kind == ExIdent {
+		return sig, nil
+	}
+	if !sig.bus || l.Index < 0 || l.Index >= len(sig.nets) {
+		return signal{}, fmt.Errorf("index %d out of range for '%s'", l.Index, l.Name)
+	}
+	return signal{nets: []int{sig.nets[l.Index]}}, nil
+}
+
+//	applyInit records the starting values given by the INIT line of chip c for
+//	an instance of it. first and firstMem are the first net and memory created
+//	for that instance; wires of chips inside it that already have a starting
+//	value keep it.
+func (b *builder) applyInit(c *Chip, sc *scope, first, firstMem int) error {
+	for name, v := range c.Init {
+		l := &Expr{Kind: ExIdent, Name: name}
+		if i := strings.Index(name, "["); i != -1 {
+			l = &Expr{Kind: ExIndex, Name: name[:i]}
+			fmt.Sscanf(name[i:], "[%d]", &l.Index)
+		}
+		sig, err := sc.target(l)
+		if err != nil {
+			return fmt.Errorf("INIT of chip %s: %v", c.Name, err)
+		}
+		for _, k := range sig.nets {
+			b.n.Init[k] = v
+		}
+	}
+	if c.InitAll == "" {
+		return nil
+	}
+	for k := first; k < len(b.n.Init); k++ {
+		if b.n.Init[k] == "" {
+			b.n.Init[k] = c.InitAll
+		}
+	}
+	for _, m := range b.n.Mems[firstMem:] {
+		for _, word := range m.Data {
+			for k := range word {
+				if word[k] == "" {
+					word[k] = c.InitAll
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//	resultShapes works out the shapes of the values an expression evaluates
+//	to. ok is false if this depends on a wire whose shape is not known yet.
+func (b *builder) resultShapes(sc *scope, e *Expr) (shapes []Shape, ok bool, err error) {
+	switch e.Kind {
+	case ExLit, ExIndex:
+		return []Shape{{1, false}}, true, nil
+	case ExIdent:
+		sig, found := sc.env[e.Name]
+		if !found {
+			return nil, false, nil
+		}
+		return []Shape{sig.shape()}, true, nil
+	}
+	if IsPrimitive(e.Name) {
+		return []Shape{{1, false}}, true, nil
+	}
+	if IsMemory(e.Name) {
+		_, width, _, err := memoryParams(e)
+		if err != nil {
+			return nil, false, err
+		}
+		return []Shape{{width, true}}, true, nil
+	}
+	c, err := b.chip(e.Name)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, o := range c.Outputs {
+		shapes = append(shapes, o.Shape)
+	}
+	return shapes, true, nil
+}
+
+//	build adds the gates computing expression e, found in chip c, to the
+//	netlist. If targets is not nil, the values of e drive those signals;
+//	otherwise new wires are created for them. The signals holding the values
+//	of e are returned.
+func (b *builder) build(sc *scope, c *Chip, e *Expr, targets []signal) ([]signal, error) {
+	if targets != nil {
+		shapes, _, err := b.resultShapes(sc, e)
+		if err != nil {
+			return nil, err
+		}
+		if len(shapes) != len(targets) {
+			return nil, fmt.Errorf("%d values assigned to %d wires", len(shapes), len(targets))
+		}
+		for k := range shapes {
+			if shapes[k] != targets[k].shape() {
+				return nil, errors.New("value assigned to a wire of the wrong size")
+			}
+		}
+	}
+
+	switch e.Kind {
+	case ExLit:
+		out := b.output(sc, targets, "const")
+		b.n.Elems = append(b.n.Elems, Element{Op: "const", Outs: out.nets, Val: e.Name, Path: sc.path + e.Name})
+		return []signal{out}, nil
+	case ExIdent, ExIndex:
+		src, err := sc.target(e)
+		if err != nil {
+			return nil, err
+		}
+		if targets == nil {
+			return []signal{src}, nil
+		}
+		for k := range src.nets {
+			b.n.Elems = append(b.n.Elems, Element{Op: "buf", Ins: []int{src.nets[k]}, Outs: []int{targets[0].nets[k]}, Path: sc.path + e.Name})
+		}
+		return targets, nil
+	}
+
+	//	arguments are elaborated first, in order, as go would evaluate them
+	var args []signal
+	for _, a := range e.Args {
+		vals, err := b.build(sc, c, a, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(vals) != 1 && len(e.Args) != 1 {
+			return nil, fmt.Errorf("multiple values of '%s' used as a single argument", a.Name)
+		}
+		args = append(args, vals...)
+	}
+
+	if IsMemory(e.Name) {
+		return b.buildMemory(sc, c, e, args, targets)
+	}
+	if IsPrimitive(e.Name) {
+		want := 2
+		if e.Name == "not" {
+			want = 1
+		}
+		if len(args) != want {
+			return nil, fmt.Errorf("'%s' takes %d inputs, %d given", e.Name, want, len(args))
+		}
+		el := Element{Op: e.Name, Path: sc.instance(e.Name), Delay: b.delay(e.Name)}
+		for _, a := range args {
+			if a.bus {
+				return nil, fmt.Errorf("buffer passed to '%s'", e.Name)
+			}
+			el.Ins = append(el.Ins, a.nets[0])
+		}
+		var out signal
+		if targets != nil {
+			out = targets[0]
+		} else {
+			out = signal{nets: []int{b.newNet(el.Path)}}
+		}
+		el.Outs = out.nets
+		b.n.Elems = append(b.n.Elems, el)
+		return []signal{out}, nil
+	}
+
+	inner, err := b.chip(e.Name)
+	if err != nil {
+		return nil, err
+	}
+	path := sc.instance(e.Name) + "."
+	if inner.Delay == 0 || b.delays == nil {
+		return b.elaborate(inner, path, args, targets)
+	}
+
+	//	a chip with a delay of its own hides the delays of the gates inside
+	//	it. Its outputs reach the wires they drive through buffers instead.
+	b.inside++
+	vals, err := b.elaborate(inner, path, args, nil)
+	b.inside--
+	if err != nil {
+		return nil, err
+	}
+	delay := inner.Delay
+	if b.inside > 0 {
+		delay = 0
+	}
+	var result []signal
+	for k, in := range vals {
+		p := inner.Outputs[k]
+		out := signal{}
+		if targets != nil {
+			out = targets[k]
+		} else {
+			out = b.newSignal(path+p.Name+"'", p.Shape)
+		}
+		for i := range in.nets {
+			b.n.Elems = append(b.n.Elems, Element{Op: "buf", Ins: []int{in.nets[i]}, Outs: []int{out.nets[i]}, Path: path + p.Name, Delay: delay})
+		}
+		result = append(result, out)
+	}
+	return result, nil
+}
+
+//	delay returns the delay of a primitive gate or memory, which is hidden
+//	inside a chip with a delay of its own
+func (b *builder) delay(op string) int {
+	if b.inside > 0 {
+		return 0
+	}
+	return b.delays[op]
+}
+
+//	output returns the signal an element should drive
+func (b *builder) output(sc *scope, targets []signal, name string) signal {
+	if targets != nil {
+		return targets[0]
+	}
+	return signal{nets: []int{b.newNet(sc.instance(name))}}
+}
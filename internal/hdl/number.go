@@ -0,0 +1,96 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+
+package hdl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//	Values given to whole buses are numbers. They may be written in binary
+//	("0b1010_0011"), hexadecimal ("0xA3"), decimal ("163"), or with their
+//	width given first, as in "8'b10100011", "8'hA3", "8'd163" or "8'hXX". '_'
+//	may be used to group digits, X digits stand for unknown bits, and a value
+//	of just "X" makes every bit unknown. Bit 0 of a bus is its least
+//	significant bit.
+
+//	ParseNumber reads a value for a wire that is width bits wide and returns
+//	its bits, least significant first
+func ParseNumber(text string, width int) ([]string, error) {
+	digits := strings.ReplaceAll(text, "_", "")
+	invalid := fmt.Errorf("invalid value '%s'", text)
+	if i := strings.Index(digits, "'"); i != -1 {
+		w, err := strconv.Atoi(digits[:i])
+		if err != nil || w < 1 || len(digits) < i+3 {
+			return nil, invalid
+		}
+		if w != width {
+			return nil, fmt.Errorf("'%s' is %d bits wide, not %d", text, w, width)
+		}
+		switch digits[i+1] {
+		case 'b', 'B':
+			digits = "0b" + digits[i+2:]
+		case 'h', 'H':
+			digits = "0x" + digits[i+2:]
+		case 'd', 'D':
+			digits = digits[i+2:]
+		default:
+			return nil, invalid
+		}
+	}
+	var bits []string
+	switch {
+	case digits == "X" || digits == "x":
+		for k := 0; k < width; k++ {
+			bits = append(bits, "X")
+		}
+	case len(digits) > 2 && (digits[:2] == "0b" || digits[:2] == "0B"):
+		b, err := digitsToBits(digits[2:], 1)
+		if err != nil {
+			return nil, invalid
+		}
+		bits = b
+	case len(digits) > 2 && (digits[:2] == "0x" || digits[:2] == "0X"):
+		b, err := digitsToBits(digits[2:], 4)
+		if err != nil {
+			return nil, invalid
+		}
+		bits = b
+	default:
+		v, err := strconv.ParseUint(digits, 10, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		for ; v > 0; v >>= 1 {
+			bits = append(bits, strconv.Itoa(int(v&1)))
+		}
+	}
+	for k := width; k < len(bits); k++ {
+		if bits[k] != "0" {
+			return nil, fmt.Errorf("'%s' does not fit in %d bits", text, width)
+		}
+	}
+	for len(bits) < width {
+		bits = append(bits, "0")
+	}
+	return bits[:width], nil
+}
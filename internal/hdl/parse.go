@@ -0,0 +1,258 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+//	Package hdl holds what the bru command and the bru package share: the
+//	parser for the header lines and CON blocks of chips, the elaborator that
+//	flattens a chip into a netlist of primitive gates, and the evaluation of
+//	that netlist. Splitting HDL files into chips, and following their LOAD
+//	blocks, is left to its users, which hand it the lines of each chip.
+package hdl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//	expression kinds
+const (
+	ExIdent = iota // a
+	ExIndex        // a[2]
+	ExCall         // f(a, b)
+	ExLit          // "1"
+)
+
+//	Expr is a parsed expression from the CON block of a chip
+type Expr struct {
+	Kind   int
+	Name   string
+	Index  int
+	Args   []*Expr
+	Params []string // parameters given in <> after the name of a memory
+}
+
+//	Stmt is a parsed assignment from the CON block of a chip
+type Stmt struct {
+	Lhs   []*Expr
+	Rhs   *Expr
+	Text  string // the line the assignment was read from
+	Where string // where the line is, put in front of errors about it
+}
+
+//	Shape of a port or wire: a single bit, or a buffer of Width bits
+type Shape struct {
+	Width int
+	Bus   bool
+}
+
+//	Port is a named input or output of a chip
+type Port struct {
+	Name string
+	Shape
+}
+
+//	IsBit reports whether v is one of the values a wire can take
+func IsBit(v string) bool {
+	return v == "0" || v == "1" || v == "X"
+}
+
+//	IsPrimitive reports whether name is one of the built in gates
+func IsPrimitive(name string) bool {
+	return name == "and" || name == "or" || name == "not"
+}
+
+//	ParsePort reads a port of a chip, eg. "a" or "a[4]"
+func ParsePort(v string) (Port, error) {
+	i := strings.Index(v, "[")
+	if i == -1 {
+		return Port{v, Shape{1, false}}, nil
+	}
+	if !strings.HasSuffix(v, "]") {
+		return Port{}, fmt.Errorf("malformed buffer '%s'", v)
+	}
+	w, err := strconv.Atoi(v[i+1 : len(v)-1])
+	if err != nil || w < 1 {
+		return Port{}, fmt.Errorf("malformed buffer size in '%s'", v)
+	}
+	return Port{v[:i], Shape{w, true}}, nil
+}
+
+//	IsStatement reports whether a line of a CON block holds an assignment.
+//	Other lines, and comments, are ignored.
+func IsStatement(line string) bool {
+	return strings.Contains(line, "=") && !strings.HasPrefix(line, "//")
+}
+
+//	ParseStatement parses an assignment from the CON block of a chip
+func ParseStatement(line string) (Stmt, error) {
+	toks, err := Tokenize(line)
+	if err != nil {
+		return Stmt{}, err
+	}
+	p := &parser{toks: toks}
+	s := Stmt{Text: line}
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return Stmt{}, err
+		}
+		if e.Kind != ExIdent && e.Kind != ExIndex {
+			return Stmt{}, fmt.Errorf("cannot assign to '%s'", e.Name)
+		}
+		s.Lhs = append(s.Lhs, e)
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect("="); err != nil {
+		return Stmt{}, err
+	}
+	if s.Rhs, err = p.expr(); err != nil {
+		return Stmt{}, err
+	}
+	if p.peek() != "" {
+		return Stmt{}, fmt.Errorf("unexpected '%s'", p.peek())
+	}
+	return s, nil
+}
+
+//	Tokenize splits a line of the CON block into identifiers, numbers, string
+//	literals and punctuation.
+func Tokenize(line string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte("()[],=<>", c) != -1:
+			toks = append(toks, string(c))
+			i++
+		case c == '"':
+			j := strings.IndexByte(line[i+1:], '"')
+			if j == -1 {
+				return nil, errors.New("unterminated literal")
+			}
+			toks = append(toks, line[i:i+j+2])
+			i += j + 2
+		case c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(line) && (line[j] == '_' || line[j] >= '0' && line[j] <= '9' ||
+				line[j] >= 'a' && line[j] <= 'z' || line[j] >= 'A' && line[j] <= 'Z') {
+				j++
+			}
+			toks = append(toks, line[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character '%c'", c)
+		}
+	}
+	return toks, nil
+}
+
+//	parser is a small recursive descent parser over the tokens of one line
+type parser struct {
+	toks []string
+	pos  int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(t string) error {
+	if got := p.next(); got != t {
+		return fmt.Errorf("expected '%s', found '%s'", t, got)
+	}
+	return nil
+}
+
+func (p *parser) expr() (*Expr, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, errors.New("unexpected end of line")
+	case strings.HasPrefix(t, "\""):
+		v := strings.Trim(t, "\"")
+		if !IsBit(v) {
+			return nil, fmt.Errorf("invalid literal %s", t)
+		}
+		return &Expr{Kind: ExLit, Name: v}, nil
+	case strings.IndexByte("()[],=<>", t[0]) != -1:
+		return nil, fmt.Errorf("unexpected '%s'", t)
+	}
+	var params []string
+	if p.peek() == "<" {
+		//	parameters of a memory, eg. rom<16, 8, "program.hex">
+		for p.next(); p.peek() != ">"; {
+			v := p.next()
+			if v == "" {
+				return nil, fmt.Errorf("unterminated parameters of '%s'", t)
+			}
+			if v != "," {
+				params = append(params, v)
+			}
+		}
+		p.next()
+		if p.peek() != "(" {
+			return nil, fmt.Errorf("expected '(' after parameters of '%s'", t)
+		}
+	}
+	switch p.peek() {
+	case "[":
+		p.next()
+		n, err := strconv.Atoi(p.next())
+		if err != nil {
+			return nil, fmt.Errorf("invalid index into '%s'", t)
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return &Expr{Kind: ExIndex, Name: t, Index: n}, nil
+	case "(":
+		p.next()
+		e := &Expr{Kind: ExCall, Name: t, Params: params}
+		for p.peek() != ")" {
+			a, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			e.Args = append(e.Args, a)
+			if p.peek() == "," {
+				p.next()
+			} else if p.peek() != ")" {
+				return nil, fmt.Errorf("expected ',' or ')' in call to '%s'", t)
+			}
+		}
+		p.next()
+		return e, nil
+	}
+	return &Expr{Kind: ExIdent, Name: t}, nil
+}
@@ -0,0 +1,211 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+
+package hdl
+
+//	A netlist is evaluated by computing every element in turn, over and over,
+//	until no net changes any more. Because a net may be read before the
+//	element that drives it has been computed, this is what lets cross coupled
+//	gates, like the two nands of an SR latch, be described in the HDL.
+
+//	State is the value of every net of a netlist, and the contents of every
+//	memory in it, at some point of a simulation
+type State struct {
+	*Netlist
+	Values []string     // value of every net
+	Data   [][][]string // contents of every memory
+}
+
+//	Start returns the state a netlist starts out in. Every net and bit of a
+//	memory without a starting value of its own takes the one returned by
+//	value, and the global reset signal starts out as 0.
+func (n *Netlist) Start(value func() string) *State {
+	s := &State{Netlist: n, Values: make([]string, len(n.Names))}
+	for k := range s.Values {
+		s.Values[k] = n.Init[k]
+		if s.Values[k] == "" {
+			s.Values[k] = value()
+		}
+	}
+	if !n.IsInput("reset") {
+		s.Values[n.Reset] = "0"
+	}
+	for _, m := range n.Mems {
+		var data [][]string
+		for _, word := range m.Data {
+			w := append([]string(nil), word...)
+			for k := range w {
+				if w[k] == "" {
+					w[k] = value()
+				}
+			}
+			data = append(data, w)
+		}
+		s.Data = append(s.Data, data)
+	}
+	return s
+}
+
+//	IsInput reports whether name is an input of the top chip
+func (n *Netlist) IsInput(name string) bool {
+	for _, p := range n.Top.Inputs {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//	Resetting reports whether the global reset signal is asserted
+func (s *State) Resetting() bool {
+	return s.Values[s.Reset] == "1"
+}
+
+//	Drive returns the value a net is driven to when its driver computes v.
+//	While reset is asserted, registers are held at their starting values.
+func (s *State) Drive(net int, v string) string {
+	if s.Init[net] != "" && s.Resetting() {
+		return s.Init[net]
+	}
+	return v
+}
+
+//	Compute returns the values an element drives, given its inputs. A ram
+//	stores the word at its inputs while it is being written to.
+func (s *State) Compute(e *Element) []string {
+	switch e.Op {
+	case "and":
+		return []string{gateAnd(s.Values[e.Ins[0]], s.Values[e.Ins[1]])}
+	case "or":
+		return []string{gateOr(s.Values[e.Ins[0]], s.Values[e.Ins[1]])}
+	case "not":
+		return []string{gateNot(s.Values[e.Ins[0]])}
+	case "buf":
+		return []string{s.Values[e.Ins[0]]}
+	case "ram", "rom":
+		return s.access(e)
+	}
+	return []string{e.Val}
+}
+
+//	access reads (and for a ram, writes) the word at the address given to a
+//	memory element
+func (s *State) access(e *Element) []string {
+	m, data := s.Mems[e.Mem], s.Data[e.Mem]
+	addr, known := 0, m.AddrBits < 31
+	for k := 0; k < m.AddrBits && known; k++ {
+		switch s.Values[e.Ins[k]] {
+		case "1":
+			addr |= 1 << uint(k)
+		case "0":
+		default:
+			known = false
+		}
+	}
+	if addr >= m.Words {
+		known = false
+	}
+	if e.Op == "ram" && known && s.Values[e.Ins[len(e.Ins)-1]] == "1" && !s.Resetting() {
+		for k := range data[addr] {
+			data[addr][k] = s.Values[e.Ins[m.AddrBits+k]]
+		}
+	}
+	out := make([]string, m.Width)
+	for k := range out {
+		out[k] = "X"
+		if known {
+			out[k] = data[addr][k]
+		}
+	}
+	return out
+}
+
+//	eval computes the outputs of a single element. It reports whether any of
+//	them changed.
+func (s *State) eval(e *Element) bool {
+	changed := false
+	for k, v := range s.Compute(e) {
+		v = s.Drive(e.Outs[k], v)
+		if s.Values[e.Outs[k]] != v {
+			s.Values[e.Outs[k]] = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+//	Settle evaluates the netlist until no net changes any more. If that does
+//	not happen within limit passes, the nets that were still changing are set
+//	to "X" and returned.
+func (s *State) Settle(limit int) []int {
+	for pass := 0; pass < limit; pass++ {
+		changed := false
+		for k := range s.Elems {
+			if s.eval(&s.Elems[k]) {
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+	}
+	before := append([]string(nil), s.Values...)
+	for k := range s.Elems {
+		s.eval(&s.Elems[k])
+	}
+	var oscillating []int
+	for k := range s.Values {
+		if s.Values[k] != before[k] {
+			oscillating = append(oscillating, k)
+		}
+	}
+	for _, k := range oscillating {
+		s.Values[k] = "X"
+	}
+	return oscillating
+}
+
+func gateNot(i string) string {
+	switch i {
+	case "1":
+		return "0"
+	case "0":
+		return "1"
+	}
+	return "X"
+}
+
+func gateAnd(a, b string) string {
+	if a == "0" || b == "0" {
+		return "0"
+	} else if a == "1" && b == "1" {
+		return "1"
+	}
+	return "X"
+}
+
+func gateOr(a, b string) string {
+	if a == "1" || b == "1" {
+		return "1"
+	} else if a == "0" && b == "0" {
+		return "0"
+	}
+	return "X"
+}
@@ -0,0 +1,59 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	Values given to whole buses, in scripts and test vectors, are numbers,
+//	read by hdl.ParseNumber.
+
+//	goAssignment translates an assignment from a script into go code for the
+//	generated program, eg. "a = 0xA" into `a = [4]string{"0", "1", "0", "1"}`
+func goAssignment(line string) (string, error) {
+	name := strings.TrimSpace(line[:strings.Index(line, "=")])
+	value := strings.TrimSpace(line[strings.Index(line, "=")+1:])
+	if name == "reset" && !strings.Contains(" "+scInArgsBits+" ", " reset ") {
+		return "", fmt.Errorf("the reset signal only works with 'bru run', not in go code made by 'bru build'")
+	}
+	width := 0
+	for _, v := range scInArgsBufs {
+		if strings.HasPrefix(v, name+"[") {
+			width, _ = strconv.Atoi(v[len(name)+1 : strings.Index(v, "]")])
+		}
+	}
+	if width == 0 {
+		bits, err := hdl.ParseNumber(value, 1)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", name, err)
+		}
+		return name + " = \"" + bits[0] + "\"", nil
+	}
+	bits, err := hdl.ParseNumber(value, width)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	return name + " = [" + strconv.Itoa(width) + "]string{\"" + strings.Join(bits, "\", \"") + "\"}", nil
+}
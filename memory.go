@@ -0,0 +1,62 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//	bitsToHex writes bits, least significant first, as a hexadecimal number.
+//	A digit with any unknown bit in it is written as X.
+func bitsToHex(bits []string) string {
+	hex := ""
+	for k := 0; k < len(bits); k += 4 {
+		v, unknown := 0, false
+		for b := k; b < k+4 && b < len(bits); b++ {
+			switch bits[b] {
+			case "1":
+				v |= 1 << uint(b-k)
+			case "0":
+			default:
+				unknown = true
+			}
+		}
+		if unknown {
+			hex = "X" + hex
+		} else {
+			hex = strconv.FormatInt(int64(v), 16) + hex
+		}
+	}
+	return strings.ToUpper(hex)
+}
+
+//	dumpMemories writes the contents of every memory in the netlist, in the
+//	format read by rom and ram.
+func (n *netlist) dumpMemories(w io.Writer) {
+	for k, m := range n.Mems {
+		fmt.Fprintf(w, "// %s (%d x %d)\n", m.Name, m.Words, m.Width)
+		for _, word := range n.Data[k] {
+			fmt.Fprintln(w, bitsToHex(word))
+		}
+	}
+}
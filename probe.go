@@ -0,0 +1,69 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"strings"
+)
+
+//	Probes are wires inside the simulated chip whose values are printed along
+//	with its outputs. They are named by their path through the chips that
+//	contain them, eg. "adder0.carry" is the wire 'carry' of the first adder
+//	used by the simulated chip.
+
+//	probeList holds the wires given to probe on the command line
+var probeList []string
+
+//	isProbe reports whether a script line is a probe directive, eg.
+//	"probe adder0.carry, s[2]"
+func isProbe(line string) bool {
+	return line == "probe" || strings.HasPrefix(line, "probe ")
+}
+
+//	probeNames returns the wires named by a probe directive
+func probeNames(line string) []string {
+	return strings.Fields(strings.ReplaceAll(strings.TrimPrefix(line, "probe"), ",", " "))
+}
+
+//	addProbes asks for wires inside the chip to be printed along with its
+//	outputs
+func (n *netlist) addProbes(names []string) error {
+	for _, name := range names {
+		if _, err := n.lookup(name); err != nil {
+			return err
+		}
+		n.probes = append(n.probes, name)
+	}
+	return nil
+}
+
+//	probeValues formats the values of the probed wires, to be printed after
+//	the outputs
+func (n *netlist) probeValues() string {
+	line := ""
+	for _, name := range n.probes {
+		v, _ := n.get(name)
+		if resultFormat != "raw" {
+			v = n.formatValue(name)
+		}
+		line += "  " + name + "=" + v
+	}
+	return line
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	Results are printed as "name=value" pairs, eg.
+//
+//		call 0 : a=0b0011 b=0b0001 c=0 -> s=0b0100 co=0
+//		t = 3 : d=1 -> q=1
+//
+//	Buses are written in the radix chosen with --radix, or with a line such
+//	as "radix hex" in a script:
+//
+//		bin        binary, most significant bit first, eg. 0b0100 (the default)
+//		hex        hexadecimal, eg. 0x4
+//		unsigned   decimal, eg. 4
+//		signed     decimal, reading the bus as a two's complement number
+//
+//	An unknown bit makes a hexadecimal digit X, and a decimal value X.
+//	"--format raw" prints results the way the generated go code does.
+
+//	radixName is the radix given with --radix
+var radixName string = "bin"
+
+//	isRadix reports whether name is a radix buses can be written in
+func isRadix(name string) bool {
+	return name == "bin" || name == "hex" || name == "unsigned" || name == "signed"
+}
+
+//	isRadixLine reports whether a script line chooses a radix, eg. "radix hex"
+func isRadixLine(line string) bool {
+	return strings.HasPrefix(line, "radix ")
+}
+
+//	formatBits writes the bits of a wire, least significant first, in the
+//	radix chosen for the netlist. Single bits are written as they are.
+func (n *netlist) formatBits(bits []string, bus bool) string {
+	if !bus {
+		return bits[0]
+	}
+	radix := n.radix
+	if radix == "" {
+		radix = radixName
+	}
+	switch radix {
+	case "hex":
+		return "0x" + bitsToHex(bits)
+	case "unsigned", "signed":
+		//	buses may be wider than any int
+		v := new(big.Int)
+		for k := len(bits) - 1; k >= 0; k-- {
+			if bits[k] != "0" && bits[k] != "1" {
+				return "X"
+			}
+			v.Lsh(v, 1)
+			if bits[k] == "1" {
+				v.SetBit(v, 0, 1)
+			}
+		}
+		if radix == "signed" && bits[len(bits)-1] == "1" {
+			v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(bits))))
+		}
+		return v.String()
+	}
+	v := "0b"
+	for k := len(bits) - 1; k >= 0; k-- {
+		v += bits[k]
+	}
+	return v
+}
+
+//	display writes a value given in the form 'get' uses, eg. "[0 0 1 0]", in
+//	the radix chosen for the netlist
+func (n *netlist) display(name, value string) string {
+	_, single := n.Index[strings.TrimPrefix(name, n.top.name+".")]
+	return n.formatBits(strings.Fields(strings.Trim(value, "[]")), !single)
+}
+
+//	labelledPorts writes the values of ports as "name=value" pairs
+func (n *netlist) labelledPorts(ports []hdl.Port) string {
+	var parts []string
+	for _, p := range ports {
+		parts = append(parts, p.Name+"="+n.formatValue(p.Name))
+	}
+	return strings.Join(parts, " ")
+}
+
+//	callLine describes the k'th call of a combinational chip: the inputs it
+//	was given and the outputs it produced
+func (n *netlist) callLine(k int) string {
+	if resultFormat == "raw" {
+		return strings.Join(n.outputValues(), " ") + n.probeValues() + n.settleTime()
+	}
+	return "call " + strconv.Itoa(k) + " : " + n.labelledPorts(n.ins) + " -> " + n.labelledPorts(n.outs) + n.probeValues() + n.settleTime()
+}
+
+//	cycleLine describes cycle t of a clocked chip: the inputs the clock gave
+//	it and the outputs it produced
+func (n *netlist) cycleLine(t int, c *clock, outs []string) string {
+	if resultFormat == "raw" {
+		return n.clockedLine(outs) + n.probeValues() + n.settleTime()
+	}
+	return "t = " + strconv.Itoa(t) + " : " + c.inputs + " -> " + n.labelledPorts(n.outs) + n.probeValues() + n.settleTime()
+}
@@ -0,0 +1,298 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+const replHelp = `commands:
+    name = value    set an input (or reset) to 0, 1 or X
+    call            evaluate the chip and print its outputs
+    step [n]        run a clocked chip for n cycles (1 if not given)
+    show [wire...]  print the outputs, or the given wires, eg. fa0.t or s[2]
+    probe wire...   print the given wires along with the outputs from now on
+    expect w = v    check that a wire has the given value
+    truthtable [x]  print the truth table of the chip, with X inputs if x is given
+    radix r         print buses in binary (bin), hex, unsigned or signed
+    wires [prefix]  list the wires of the chip whose names start with prefix
+    sim chip        simulate another chip
+    reload          read the hdl file again, keeping the inputs that still exist
+    history         list the commands entered so far
+    !!, !n          repeat the last command, or command number n
+    help            print this message
+    quit            leave bru`
+
+//	repl is an interactive session with a chip
+type repl struct {
+	hdlFile string
+	top     string // name of the chip being simulated
+	n       *netlist
+	clk     *clock
+	cycle   int
+	history []string
+	out     io.Writer
+}
+
+//	runRepl starts an interactive session with the chip scheduled for
+//	simulation in the given hdl file
+func runRepl(hdlFile string) {
+	r := &repl{hdlFile: hdlFile, out: os.Stdout}
+	for _, c := range chips {
+		if c.simulate {
+			r.top = c.name
+			break
+		}
+	}
+	if r.top != "" {
+		if err := r.build(nil); err != nil {
+			fmt.Fprintln(messages, "ERROR: "+err.Error())
+		}
+	}
+	fmt.Fprintln(r.out, "bru : type 'help' for a list of commands")
+	r.loop(os.Stdin)
+}
+
+//	loop reads and runs commands until the input ends or the user quits
+func (r *repl) loop(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(r.out, "bru> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(r.out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			var err error
+			if line, err = r.recall(line); err != nil {
+				fmt.Fprintln(r.out, "ERROR: "+err.Error())
+				continue
+			}
+			fmt.Fprintln(r.out, line)
+		}
+		r.history = append(r.history, line)
+		if line == "quit" || line == "exit" {
+			return
+		}
+		if err := r.command(line); err != nil {
+			fmt.Fprintln(r.out, "ERROR: "+err.Error())
+		}
+	}
+}
+
+//	recall returns the command from the history that "!!" or "!n" refers to
+func (r *repl) recall(line string) (string, error) {
+	if len(r.history) == 0 {
+		return "", errors.New("no commands entered yet")
+	}
+	if line == "!!" {
+		return r.history[len(r.history)-1], nil
+	}
+	k, err := strconv.Atoi(line[1:])
+	if err != nil || k < 1 || k > len(r.history) {
+		return "", fmt.Errorf("no command number '%s' in the history", line[1:])
+	}
+	return r.history[k-1], nil
+}
+
+//	build elaborates the chip being simulated afresh, setting its inputs to
+//	the given values where they still exist
+func (r *repl) build(inputs map[string]string) error {
+	c := findChip(r.top)
+	if c == nil {
+		return errors.New("chip " + r.top + " not found")
+	}
+	n, err := buildNetlist(c, chips)
+	if err != nil {
+		return err
+	}
+	for name, v := range inputs {
+		if n.isInput(name) {
+			n.set(name, v)
+		}
+	}
+	r.n, r.clk, r.cycle = n, newClock(n), 0
+	return nil
+}
+
+//	command runs a single command
+func (r *repl) command(line string) error {
+	fields := strings.Fields(line)
+	switch {
+	case fields[0] == "help":
+		fmt.Fprintln(r.out, replHelp)
+		return nil
+	case fields[0] == "history":
+		for k, v := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", k+1, v)
+		}
+		return nil
+	case fields[0] == "reload":
+		return r.reload()
+	case fields[0] == "sim":
+		if len(fields) != 2 {
+			return errors.New("usage: sim chip")
+		}
+		old := r.top
+		r.top = fields[1]
+		if err := r.build(nil); err != nil {
+			r.top = old
+			return err
+		}
+		return nil
+	}
+
+	if r.n == nil {
+		return errors.New("no chip to simulate, pick one with 'sim chip'")
+	}
+	switch {
+	case isCheck(line):
+		c, err := parseCheck(line, "line "+strconv.Itoa(len(r.history)))
+		if err != nil {
+			return err
+		}
+		failed := len(r.n.failures)
+		if err := r.n.verify(c); err != nil {
+			return err
+		}
+		if len(r.n.failures) > failed {
+			fmt.Fprintln(r.out, "FAIL : "+r.n.failures[failed])
+		} else {
+			fmt.Fprintln(r.out, "PASS")
+		}
+	case strings.Contains(line, "="):
+		a, err := parseAssignment(line)
+		if err != nil {
+			return err
+		}
+		return r.n.apply([]assignment{a})
+	case fields[0] == "call":
+		if r.n.top.clocked {
+			return errors.New("CLOCKED chip not compatible with \"call\" command, use 'step'")
+		}
+		r.n.evaluate()
+		fmt.Fprintln(r.out, r.labelled(r.n.outs)+r.n.probeValues())
+	case fields[0] == "step":
+		if !r.n.top.clocked {
+			return errors.New("only CLOCKED chips can be stepped, use 'call'")
+		}
+		count := 1
+		if len(fields) > 1 {
+			var err error
+			if count, err = strconv.Atoi(fields[1]); err != nil || count < 1 {
+				return errors.New("usage: step [n]")
+			}
+		}
+		for k := 0; k < count; k++ {
+			outs, err := r.clk.tick()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(r.out, r.n.cycleLine(r.cycle, r.clk, outs))
+			r.cycle++
+		}
+	case isTruthTable(line):
+		return r.n.truthTable(len(fields) > 1 && fields[1] == "x", r.out)
+	case fields[0] == "probe":
+		return r.n.addProbes(probeNames(line))
+	case fields[0] == "radix":
+		if len(fields) != 2 || !isRadix(fields[1]) {
+			return errors.New("usage: radix bin|hex|unsigned|signed")
+		}
+		radixName = fields[1]
+	case fields[0] == "show":
+		if len(fields) == 1 {
+			fmt.Fprintln(r.out, r.labelled(r.n.outs))
+			return nil
+		}
+		for _, name := range fields[1:] {
+			if _, err := r.n.lookup(name); err != nil {
+				return err
+			}
+			fmt.Fprintln(r.out, name+" = "+r.n.formatValue(name))
+		}
+	case fields[0] == "wires":
+		prefix := ""
+		if len(fields) > 1 {
+			prefix = fields[1]
+		}
+		var names []string
+		for name := range r.n.Index {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintln(r.out, "    "+name)
+		}
+	default:
+		return errors.New("unknown command '" + fields[0] + "', type 'help' for a list of commands")
+	}
+	return nil
+}
+
+//	labelled formats the values of ports as "name = value" pairs
+func (r *repl) labelled(ports []hdl.Port) string {
+	var parts []string
+	for _, p := range ports {
+		parts = append(parts, p.Name+" = "+r.n.formatValue(p.Name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+//	reload reads the hdl file again and rebuilds the chip being simulated,
+//	keeping the values of its inputs
+func (r *repl) reload() error {
+	inputs := map[string]string{}
+	if r.n != nil {
+		for _, p := range r.n.ins {
+			nets, _ := r.n.lookup(p.Name)
+			for k, id := range nets {
+				name := p.Name
+				if p.Bus {
+					name += "[" + strconv.Itoa(k) + "]"
+				}
+				inputs[name] = r.n.Values[id]
+			}
+		}
+	}
+	if err := readHDL(r.hdlFile); err != nil {
+		return err
+	}
+	if err := r.build(inputs); err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, "reloaded "+r.hdlFile)
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"math/rand"
+)
+
+//	Every wire starts out with the value given by --init, which is X unless
+//	told otherwise. A chip can choose the value its own wires (and memories)
+//	start with using an INIT line, eg. "INIT 0", and the starting value of
+//	particular wires, eg. "INIT q=1 qn=0". Those wires are the chip's registers:
+//	while the global reset signal is 1, they are held at their starting values
+//	and rams go back to what they held at the start.
+
+//	initValue is the value every wire starts with: 0, 1, X or random
+var initValue string = "X"
+
+//	startingValue returns the value a wire with no starting value of its own
+//	starts with
+func startingValue() string {
+	if initValue == "random" {
+		return []string{"0", "1"}[rand.Intn(2)]
+	}
+	return initValue
+}
+
+//	resetMemories puts the starting contents back into every memory
+func (n *netlist) resetMemories() {
+	for k, data := range n.Data {
+		for w := range data {
+			copy(data[w], n.initial[k][w])
+		}
+	}
+}
@@ -0,0 +1,575 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	assignment is an input assignment from a script, eg. "i1 = 1", "a[0] = X"
+//	or "a = 0xA3"
+type assignment struct {
+	name  string
+	value string
+	bits  []string // if not nil, the value of every bit of a bus, bit 0 first
+}
+
+//	parseAssignment parses a single input assignment from a script
+func parseAssignment(line string) (assignment, error) {
+	name := strings.TrimSpace(line[:strings.Index(line, "=")])
+	value := strings.TrimSpace(line[strings.Index(line, "=")+1:])
+	if value == "" || strings.ContainsAny(value, " \t") {
+		return assignment{}, newError(parseError, "invalid value '%s' for '%s'", value, name)
+	}
+	if i := strings.Index(name, "["); i != -1 {
+		if _, err := strconv.Atoi(strings.TrimSuffix(name[i+1:], "]")); err != nil {
+			return assignment{}, newError(parseError, "invalid index in '%s'", name)
+		}
+	}
+	return assignment{name: name, value: value}, nil
+}
+
+//	apply sets the inputs of the netlist as described by the assignments
+func (n *netlist) apply(as []assignment) error {
+	for _, a := range as {
+		if !n.isInput(a.name) && a.name != "reset" {
+			return fmt.Errorf("'%s' is not an input of %s", a.name, n.top.name)
+		}
+		if a.name == "reset" && a.value == "1" && !n.Resetting() {
+			n.resetMemories()
+		}
+		bits := a.bits
+		if bits == nil {
+			nets, err := n.lookup(a.name)
+			if err != nil {
+				return err
+			}
+			if bits, err = hdl.ParseNumber(a.value, len(nets)); err != nil {
+				return newError(parseError, "%s: %v", a.name, err)
+			}
+		}
+		if err := n.setBits(a.name, bits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	setBits assigns a value to each bit of a top level wire
+func (n *netlist) setBits(name string, bits []string) error {
+	nets, err := n.lookup(name)
+	if err != nil {
+		return err
+	}
+	if len(nets) != len(bits) {
+		return fmt.Errorf("'%s' is %d bits wide, %d bits given", name, len(nets), len(bits))
+	}
+	for k, id := range nets {
+		n.Values[id] = bits[k]
+	}
+	return nil
+}
+
+//	isInput reports whether name refers to (a bit of) an input of the top chip
+func (n *netlist) isInput(name string) bool {
+	if i := strings.Index(name, "["); i != -1 {
+		name = name[:i]
+	}
+	for _, p := range n.ins {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//	isOutput reports whether name refers to (a bit of) an output of the top
+//	chip
+func (n *netlist) isOutput(name string) bool {
+	if i := strings.Index(name, "["); i != -1 {
+		name = name[:i]
+	}
+	for _, p := range n.outs {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//	outputValues returns the current values of all the outputs of the top chip
+func (n *netlist) outputValues() []string {
+	var vals []string
+	for _, p := range n.outs {
+		v, _ := n.get(p.Name)
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+//	runCombinational runs a script for a combinational chip. Every "call"
+//	evaluates the chip and prints its outputs, like the generated go code does.
+func runCombinational(n *netlist, sim simulation, out io.Writer) error {
+	return runScript(n, sim, nil, out)
+}
+
+//	call evaluates a combinational chip and prints its outputs
+func (n *netlist) call(out io.Writer) {
+	n.evaluate()
+	n.sample()
+	if n.printing() {
+		fmt.Fprintln(out, n.callLine(n.calls))
+	}
+	n.calls++
+	n.reportHazards(out)
+}
+
+//	clockedScript is a parsed script for a clocked chip
+type clockedScript struct {
+	dur     int
+	init    []assignment         // assignments made before 'dur'
+	always  []assignment         // assignments outside any 't' block
+	blocks  map[int][]assignment // assignments made at a given time
+	probes  []string             // wires to print along with the outputs
+	checks  map[int][]check      // checks made at a given time
+	vectors string               // file holding test vectors, if any
+	radix   string               // radix buses are printed in, if chosen
+}
+
+//	parseClockedScript parses a script for a clocked chip
+func parseClockedScript(sim simulation) (*clockedScript, error) {
+	s := &clockedScript{dur: -1, blocks: map[int][]assignment{}, checks: map[int][]check{}}
+	block := -1
+	for k, v := range returnLines(sim.script) {
+		fields := strings.Fields(strings.NewReplacer("=", " = ", "{", " { ").Replace(v))
+		switch {
+		case v == "" || strings.HasPrefix(v, "//"):
+		case isProbe(v):
+			s.probes = append(s.probes, probeNames(v)...)
+		case isRadixLine(v):
+			s.radix = strings.TrimSpace(v[len("radix"):])
+			if !isRadix(s.radix) {
+				return nil, newError(parseError, "%s : unknown radix '%s'", sim.where(k), s.radix)
+			}
+		case isVectors(v):
+			if s.vectors != "" {
+				return nil, newError(parseError, "only one vectors file may be used in a clocked script")
+			}
+			s.vectors = vectorFile(v)
+		case isCheck(v):
+			if block == -1 {
+				return nil, newError(parseError, "%s : checks must be made inside a 't' block", sim.where(k))
+			}
+			c, err := parseCheck(v, sim.where(k))
+			if err != nil {
+				return nil, err
+			}
+			s.checks[block] = append(s.checks[block], c)
+		case v == "}":
+			if block == -1 {
+				return nil, newError(parseError, "'}' without a matching 't' block")
+			}
+			block = -1
+		case len(fields) >= 3 && fields[0] == "dur" && fields[1] == "=":
+			if s.dur != -1 {
+				return nil, newError(parseError, "'dur' declared more than once")
+			}
+			d, err := strconv.Atoi(fields[2])
+			if err != nil || d < 0 {
+				return nil, newError(parseError, "invalid duration '%s'", fields[2])
+			}
+			s.dur = d
+		case len(fields) == 4 && fields[0] == "t" && fields[1] == "=" && fields[3] == "{":
+			t, err := strconv.Atoi(fields[2])
+			if err != nil || t < 0 {
+				return nil, newError(parseError, "invalid time '%s'", fields[2])
+			}
+			block = t
+		case strings.Contains(v, "="):
+			a, err := parseAssignment(v)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case block != -1:
+				s.blocks[block] = append(s.blocks[block], a)
+			case s.dur == -1:
+				s.init = append(s.init, a)
+			default:
+				s.always = append(s.always, a)
+			}
+		case v == "call" || isTruthTable(v):
+			return nil, errors.New("CLOCKED chip not compatible with \"" + strings.Fields(v)[0] + "\" command")
+		}
+	}
+	if s.dur == -1 && s.vectors == "" {
+		return nil, newError(scriptError, "'dur' not declared")
+	}
+	return s, nil
+}
+
+//	clock runs a clocked chip one cycle at a time. The chip is evaluated in
+//	every cycle, and its looped back outputs are fed to its inputs after it.
+type clock struct {
+	n      *netlist
+	inputs string // inputs during the last cycle, as "name=value" pairs
+}
+
+//	newClock starts clocking a netlist from its current state
+func newClock(n *netlist) *clock {
+	return &clock{n: n}
+}
+
+//	tick runs a single cycle and returns the outputs of the chip during it
+func (c *clock) tick() ([]string, error) {
+	n := c.n
+	c.inputs = n.labelledPorts(n.ins)
+	n.evaluate()
+	outs := n.outputValues()
+	n.cycleInputs = append([]string(nil), n.Values...)
+	for _, l := range n.top.loops {
+		ins, err := n.lookup(l[0])
+		if err != nil {
+			return nil, err
+		}
+		fed, err := n.lookup(l[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(ins) != len(fed) {
+			return nil, newError(semanticError, "%s and %s are of different sizes", l[0], l[1])
+		}
+		for k := range ins {
+			n.Values[ins[k]] = n.Values[fed[k]]
+		}
+	}
+	return outs, nil
+}
+
+//	clockedLine formats the outputs of a clocked chip during one cycle, the
+//	way the generated go code does
+func (n *netlist) clockedLine(outs []string) string {
+	line := ""
+	for k, p := range n.outs {
+		if p.Bus {
+			line += "[ " + strings.Join(strings.Fields(strings.Trim(outs[k], "[]")), " ") + " ] "
+		} else {
+			line += outs[k] + " "
+		}
+	}
+	return line
+}
+
+//	runClocked runs a script for a clocked chip, producing one line of outputs
+//	per cycle in the same format as the generated go code.
+func runClocked(n *netlist, sim simulation, out io.Writer) error {
+	for _, v := range returnLines(sim.script) {
+		if isControl(v) {
+			//	the script is run a line at a time, with 'step'
+			return runScript(n, sim, newClock(n), out)
+		}
+	}
+	s, err := parseClockedScript(sim)
+	if err != nil {
+		return err
+	}
+	if s.vectors != "" {
+		vectors, err := n.loadVectors(hdl.RelativeTo(sim.file, s.vectors))
+		if err != nil {
+			return err
+		}
+		for t, v := range vectors {
+			s.blocks[t] = append(s.blocks[t], v.inputs...)
+			s.checks[t] = append(s.checks[t], v.checks...)
+		}
+		if s.dur == -1 {
+			s.dur = len(vectors)
+		}
+	}
+	if err := n.apply(s.init); err != nil {
+		return err
+	}
+	if err := n.addProbes(s.probes); err != nil {
+		return err
+	}
+	if s.radix != "" {
+		n.radix = s.radix
+	}
+	c := newClock(n)
+	for t := 0; t < s.dur; t++ {
+		outs, err := c.tick()
+		if err != nil {
+			return err
+		}
+		line := n.cycleLine(t, c, outs)
+		n.sample()
+		for _, c := range s.checks[t] {
+			if err := n.verify(c); err != nil {
+				return err
+			}
+		}
+		if err := n.apply(s.always); err != nil {
+			return err
+		}
+		if err := n.apply(s.blocks[t]); err != nil {
+			return err
+		}
+		if n.printing() {
+			fmt.Fprintln(out, line)
+		}
+		n.reportHazards(out)
+	}
+	return nil
+}
+
+//	settleTime describes how long the last evaluation took to settle, when
+//	simulating with delays
+func (n *netlist) settleTime() string {
+	if n.timed == nil {
+		return ""
+	}
+	if n.timed.abandoned {
+		return "  (did not settle)"
+	}
+	return "  (settled after " + strconv.Itoa(n.timed.settled) + ")"
+}
+
+//	simulation pairs a chip with the script it is simulated with
+type simulation struct {
+	top    *chip
+	script string
+	file   string // the script file the script was read from
+	first  int    // number of the line of the file the script starts at
+}
+
+//	where names a line of the script by its place in the script file, eg.
+//	"adder.scr:12"
+func (s simulation) where(k int) string {
+	return fmt.Sprintf("%s:%d", s.file, s.first+k)
+}
+
+//	findChip returns the chip with the given name
+func findChip(name string) *chip {
+	for k := range chips {
+		if chips[k].name == name {
+			return &chips[k]
+		}
+	}
+	return nil
+}
+
+//	planSimulations pairs chips with scripts. A script may be split into
+//	sections, each starting with a line "* chipname" naming the chip it is
+//	for. Scripts, or the parts of scripts before the first such line, are
+//	paired with the chips marked SIM in the order they were declared. Chips
+//	marked SIM that are left without a script are warned about.
+func planSimulations(scriptFiles []string) ([]simulation, error) {
+	var simChips []*chip
+	for k := range chips {
+		if chips[k].simulate {
+			simChips = append(simChips, &chips[k])
+		}
+	}
+	var sims []simulation
+	next := 0
+	for _, f := range scriptFiles {
+		scriptData, err := loadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(scriptData) == "" {
+			return nil, newError(scriptError, "script file %s is empty", f)
+		}
+		var current *simulation
+		for k, v := range strings.Split(scriptData, "\n") {
+			line := strings.TrimSpace(v)
+			if strings.HasPrefix(line, "*") {
+				name := strings.TrimSpace(line[1:])
+				c := findChip(name)
+				if c == nil {
+					return nil, newError(scriptError, "script %s is for chip %s, which does not exist", f, name)
+				}
+				sims = append(sims, simulation{top: c, file: f, first: k + 2})
+				current = &sims[len(sims)-1]
+				continue
+			}
+			if current == nil {
+				if line == "" || strings.HasPrefix(line, "//") {
+					continue
+				}
+				if next >= len(simChips) {
+					return nil, newError(scriptError, "no chip scheduled for simulation with script %s", f)
+				}
+				sims = append(sims, simulation{top: simChips[next], file: f, first: k + 1})
+				next++
+				current = &sims[len(sims)-1]
+			}
+			current.script += v + "\n"
+		}
+	}
+	for _, c := range simChips {
+		scripted := false
+		for _, sim := range sims {
+			scripted = scripted || sim.top == c
+		}
+		if !scripted {
+			fmt.Fprintln(messages, "WARNING : no script given for chip "+c.name+", it is not simulated")
+		}
+	}
+	return sims, nil
+}
+
+//	simulate runs a single simulation, writing its results to out
+func (s simulation) simulate(timed, hazards bool, out io.Writer) (*netlist, error) {
+	n, err := buildNetlist(s.top, chips)
+	if err != nil {
+		return nil, classify(semanticError, err)
+	}
+	if err := n.addProbes(probeList); err != nil {
+		return nil, classify(semanticError, err)
+	}
+	if vcdFile != "" {
+		newVCD(n)
+	}
+	if drawWaves {
+		newWave(n)
+	}
+	if timed || hazards {
+		var trace io.Writer
+		if traceNets {
+			trace = out
+		}
+		newTimedSim(n, trace).watch = hazards
+	}
+	if s.top.clocked {
+		err = runClocked(n, s, out)
+	} else {
+		err = runCombinational(n, s, out)
+	}
+	if err != nil {
+		//	malformed scripts and missing files were given their kinds where
+		//	they were found, anything else stopped the script from running
+		return nil, classify(scriptError, err)
+	}
+	if n.wave != nil {
+		n.wave.draw(out)
+	}
+	if resultFormat == "table" || resultFormat == "csv" {
+		n.writeResults(out)
+	}
+	return n, nil
+}
+
+//	evalScripts evaluates chips in-process, instead of generating go code
+//	for them. Every script is paired with the chip it is for, and when more
+//	than one chip is simulated, the results for each are preceded by its name.
+//	The results are written to out, except for those of clocked chips, which
+//	are written to clockedOut if it is not nil. If timed is set, the chips are
+//	simulated with gate delays, and if hazards is set, they are also checked
+//	for hazards. The netlists simulated are returned.
+func evalScripts(scriptFiles []string, timed, hazards bool, out, clockedOut io.Writer) ([]*netlist, error) {
+	sims, err := planSimulations(scriptFiles)
+	if err != nil {
+		return nil, err
+	}
+	used := map[io.Writer]bool{}
+	var nets []*netlist
+	for _, s := range sims {
+		w := out
+		if s.top.clocked && clockedOut != nil {
+			w = clockedOut
+		}
+		if resultFormat == "json" {
+			//	everything is written as a single document afterwards
+			w = ioutil.Discard
+		}
+		if len(sims) > 1 && resultFormat != "json" {
+			if used[w] {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, "* "+s.top.name)
+		}
+		used[w] = true
+		n, err := s.simulate(timed, hazards, w)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.top.name, err)
+		}
+		if n.vcd != nil {
+			if err := writeToFile(vcdName(s.top.name, len(sims) > 1), n.vcd.String()); err != nil {
+				return nil, err
+			}
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+//	runEval evaluates chips in-process and prints the results. The outputs
+//	of clocked chips are written to the output file if one was given. If any
+//	check made by the scripts fails, the status returned is exitFailed.
+func runEval(scriptFiles []string, timed, hazards bool) int {
+	var outFile, dump strings.Builder
+	var clockedOut io.Writer
+	if outFileName != "" {
+		clockedOut = &outFile
+	}
+	nets, err := evalScripts(scriptFiles, timed, hazards, os.Stdout, clockedOut)
+	if err != nil {
+		return reportError(err)
+	}
+	for _, n := range nets {
+		n.dumpMemories(&dump)
+	}
+	if outFile.Len() > 0 {
+		if err := writeToFile(outFileName, outFile.String()); err != nil {
+			return reportError(err)
+		}
+	}
+	if dumpFile == "-" {
+		fmt.Print(dump.String())
+	} else if dumpFile != "" {
+		if err := writeToFile(dumpFile, dump.String()); err != nil {
+			return reportError(err)
+		}
+	}
+	if resultFormat == "json" {
+		if err := writeJSON(nets, os.Stdout); err != nil {
+			return reportError(err)
+		}
+		for _, n := range nets {
+			if len(n.failures) > 0 {
+				return exitFailed
+			}
+		}
+		return exitOK
+	}
+	if reportChecks(nets, os.Stdout) > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,192 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const half = `
+* half
+IN a b
+OUT s c
+CON
+    s = or(and(a, not(b)), and(not(a), b))
+    c = and(a, b)
+END
+`
+
+//	simulateSource reads hdl source from a file of its own and simulates the
+//	chip marked SIM in it with a script. The results and the messages printed
+//	are returned.
+func simulateSource(t *testing.T, src, script string) (string, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "bru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "chip.hdl")
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out, msgs strings.Builder
+	defer func(w io.Writer) { messages = w }(messages)
+	messages = &msgs
+	if err := readHDL(file); err != nil {
+		t.Fatalf("readHDL: %v", err)
+	}
+	var top *chip
+	for k := range chips {
+		if chips[k].simulate {
+			top = &chips[k]
+		}
+	}
+	if top == nil {
+		t.Fatal("no chip marked SIM")
+	}
+	sim := simulation{top: top, script: script, file: "script", first: 1}
+	if _, err := sim.simulate(false, false, &out); err != nil {
+		t.Fatalf("simulate: %v", err)
+	}
+	return out.String(), msgs.String()
+}
+
+func TestSettle(t *testing.T) {
+	src := `
+* nand
+IN a b
+OUT o
+CON
+    o = not(and(a, b))
+END
+
+* srlatch
+SIM
+IN s r
+OUT q qn
+CON
+    q = nand(s, qn)
+    qn = nand(r, q)
+END
+`
+	out, msgs := simulateSource(t, src, "s = 0\nr = 1\ncall\ns = 1\ncall\nr = 0\ncall\nr = 1\ncall\n")
+	want := `call 0 : s=0 r=1 -> q=1 qn=0
+call 1 : s=1 r=1 -> q=1 qn=0
+call 2 : s=1 r=0 -> q=0 qn=1
+call 3 : s=1 r=1 -> q=0 qn=1
+`
+	if out != want {
+		t.Errorf("latch gave\n%s\nwant\n%s", out, want)
+	}
+	if msgs != "" {
+		t.Errorf("latch settled with messages %q", msgs)
+	}
+}
+
+func TestOscillation(t *testing.T) {
+	src := `
+* osc
+SIM
+IN e
+OUT o
+CON
+    o = and(e, not(o))
+END
+`
+	out, msgs := simulateSource(t, src, "e = 0\ncall\ne = 1\ncall\n")
+	want := "call 0 : e=0 -> o=0\ncall 1 : e=1 -> o=X\n"
+	if out != want {
+		t.Errorf("oscillator gave\n%s\nwant\n%s", out, want)
+	}
+	if !strings.Contains(msgs, "WARNING : osc did not settle, oscillating nets : o") {
+		t.Errorf("oscillator warned %q, want a warning naming o", msgs)
+	}
+}
+
+func TestClockedFeedback(t *testing.T) {
+	src := half + `
+* counter
+SIM
+CLK
+INIT q0=0 q1=0
+IN e (q0|n0) (q1|n1)
+OUT n0 n1
+CON
+    n0, c0 = half(q0, e)
+    n1, c1 = half(q1, c0)
+END
+`
+	//	the first cycle is evaluated with the starting values of the looped
+	//	back inputs, and every cycle shows the inputs before feedback
+	out, _ := simulateSource(t, src, "e = 1\ndur = 5\n")
+	want := `t = 0 : e=1 q0=0 q1=0 -> n0=1 n1=0
+t = 1 : e=1 q0=1 q1=0 -> n0=0 n1=1
+t = 2 : e=1 q0=0 q1=1 -> n0=1 n1=1
+t = 3 : e=1 q0=1 q1=1 -> n0=0 n1=0
+t = 4 : e=1 q0=0 q1=0 -> n0=1 n1=0
+`
+	if out != want {
+		t.Errorf("counter gave\n%s\nwant\n%s", out, want)
+	}
+
+	defer func(f string) { resultFormat = f }(resultFormat)
+	resultFormat = "csv"
+	out, _ = simulateSource(t, src, "e = 1\ndur = 3\n")
+	want = `cycle,e,q0,q1,n0,n1,checks
+0,1,0,0,1,0,
+1,1,1,0,0,1,
+2,1,0,1,1,1,
+`
+	if out != want {
+		t.Errorf("counter gave the csv\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestBusFeedback(t *testing.T) {
+	src := half + `
+* count
+SIM
+CLK
+INIT q=0
+IN en (q[3]|n[3])
+OUT n[3]
+CON
+    n[0], c0 = half(q[0], en)
+    n[1], c1 = half(q[1], c0)
+    n[2], c2 = half(q[2], c1)
+END
+`
+	out, _ := simulateSource(t, src, "en = 1\ndur = 9\n")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("counter ran %d cycles, want 9:\n%s", len(lines), out)
+	}
+	for k, v := range []string{"000", "001", "010", "011", "100", "101", "110", "111", "000"} {
+		if !strings.Contains(lines[k], "q=0b"+v+" ") {
+			t.Errorf("cycle %d of the counter is %q, want q=0b%s", k, lines[k], v)
+		}
+	}
+}
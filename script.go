@@ -0,0 +1,752 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	Scripts run by bru itself can do more than assign inputs and 'call' the
+//	chip. They can also use
+//
+//		let i = 0                   integer variables
+//		i = i + 1                   (assignments to variables)
+//		for i = 0 to 7 { ... }      counting loops, both ends included
+//		repeat 4 { ... }            loops that run a number of times
+//		while co == 0 { ... }       loops that run while a condition holds
+//		if s == 0xF { ... } else { ... }
+//		proc write(addr, val) { ... }   procedures, used as write(3, 0xA5)
+//
+//	Blocks start with '{' at the end of a line and end with a line holding
+//	just '}' (or '} else {'). Variables, numbers and wires of the chip can be
+//	used in expressions with the operators + - * / % == != < <= > >= && || !
+//	and parentheses. A wire used in an expression is read as an unsigned
+//	number, bit 0 being its least significant bit. Inputs can be given the
+//	value of an expression, eg. "a = i * 2". Clocked chips whose script does
+//	not declare 'dur' are run a line at a time too, with "step" (or "step n")
+//	running the chip for one (or n) cycles.
+
+//	loopLimit is the most times a loop may run, and callLimit the deepest
+//	procedures may call each other, before the script is given up on
+var loopLimit int = 100000
+var callLimit int = 1000
+
+//	statement kinds
+const (
+	stSimple = iota // a line handled like in a plain script, eg. "call"
+	stLet
+	stIf
+	stFor
+	stRepeat
+	stWhile
+	stProc
+	stCallProc
+)
+
+//	statement is a parsed line of a script, along with the block following it
+type statement struct {
+	kind   int
+	line   int    // index of the line in the script
+	text   string // the line itself
+	name   string // variable, loop variable or procedure
+	expr   string // value, condition, start of a for loop or number of repeats
+	end    string // end of a for loop
+	params []string
+	args   []string
+	body   []statement
+	orElse []statement
+}
+
+//	procedure is a procedure declared by a script
+type procedure struct {
+	params []string
+	body   []statement
+}
+
+//	interpreter runs a script against a netlist
+type interpreter struct {
+	n      *netlist
+	sim    simulation
+	out    io.Writer
+	procs  map[string]procedure
+	frames []map[string]int64 // variables, the global ones first
+	clk    *clock             // if not nil, the chip is clocked
+	depth  int                // depth of procedure calls
+	cycle  int                // number of cycles stepped so far
+}
+
+//	isControl reports whether a script line is part of the control layer of
+//	scripts, which only bru itself can run
+func isControl(line string) bool {
+	for _, k := range []string{"let ", "if ", "for ", "repeat ", "while ", "proc ", "} else"} {
+		if strings.HasPrefix(line, k) {
+			return true
+		}
+	}
+	return line == "step" || strings.HasPrefix(line, "step ") || isProcCall(line)
+}
+
+//	isProcCall reports whether a script line calls a procedure, eg. "write(3, 4)"
+func isProcCall(line string) bool {
+	i := strings.Index(line, "(")
+	return i > 0 && strings.HasSuffix(line, ")") && !strings.Contains(line, "=") && isName(line[:i])
+}
+
+//	isName reports whether s can name a variable or procedure
+func isName(s string) bool {
+	for k, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || k > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+//	parseScript parses a whole script into statements
+func parseScript(sim simulation) ([]statement, error) {
+	lines := returnLines(sim.script)
+	k := 0
+	body, closer, err := parseBlock(sim, lines, &k, true)
+	if err != nil {
+		return nil, err
+	}
+	if closer != "" {
+		return nil, newError(parseError, "%s : '}' without a matching '{'", sim.where(k-1))
+	}
+	return body, nil
+}
+
+//	parseBlock parses statements until the end of the block they are in,
+//	returning them along with the line that ended the block ("" at the end of
+//	the script)
+func parseBlock(sim simulation, lines []string, k *int, top bool) ([]statement, string, error) {
+	var body []statement
+	for *k < len(lines) {
+		v := lines[*k]
+		line := *k
+		*k++
+		where := sim.where(line)
+		opens := strings.HasSuffix(v, "{")
+		head := strings.TrimSpace(strings.TrimSuffix(v, "{"))
+		st := statement{line: line, text: v}
+		switch {
+		case v == "" || strings.HasPrefix(v, "//"):
+			continue
+		case v == "}" || strings.HasPrefix(v, "} else"):
+			if top {
+				return nil, "", newError(parseError, "%s : '%s' without a matching '{'", where, v)
+			}
+			return body, v, nil
+		case strings.HasPrefix(v, "proc ") && opens:
+			if !top {
+				return nil, "", newError(parseError, "%s : procedures can only be declared outside any block", where)
+			}
+			i, j := strings.Index(head, "("), strings.LastIndex(head, ")")
+			if i == -1 || j < i || !isName(strings.TrimSpace(head[5:i])) {
+				return nil, "", newError(parseError, "%s : expected 'proc name(param, ...) {'", where)
+			}
+			st.kind, st.name = stProc, strings.TrimSpace(head[5:i])
+			for _, p := range strings.Split(head[i+1:j], ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					if !isName(p) {
+						return nil, "", newError(parseError, "%s : invalid parameter '%s'", where, p)
+					}
+					st.params = append(st.params, p)
+				}
+			}
+		case strings.HasPrefix(v, "if ") && opens:
+			st.kind, st.expr = stIf, strings.TrimSpace(strings.TrimPrefix(head, "if"))
+			if st.expr == "" {
+				return nil, "", newError(parseError, "%s : expected 'if condition {'", where)
+			}
+		case strings.HasPrefix(v, "while ") && opens:
+			st.kind, st.expr = stWhile, strings.TrimSpace(strings.TrimPrefix(head, "while"))
+			if st.expr == "" {
+				return nil, "", newError(parseError, "%s : expected 'while condition {'", where)
+			}
+		case strings.HasPrefix(v, "repeat ") && opens:
+			st.kind, st.expr = stRepeat, strings.TrimSpace(strings.TrimPrefix(head, "repeat"))
+			if st.expr == "" {
+				return nil, "", newError(parseError, "%s : expected 'repeat count {'", where)
+			}
+		case strings.HasPrefix(v, "for ") && opens:
+			eq, to := strings.Index(head, "="), strings.Index(head, " to ")
+			if eq == -1 || to < eq || !isName(strings.TrimSpace(head[4:eq])) {
+				return nil, "", newError(parseError, "%s : expected 'for name = start to end {'", where)
+			}
+			st.kind, st.name = stFor, strings.TrimSpace(head[4:eq])
+			st.expr, st.end = strings.TrimSpace(head[eq+1:to]), strings.TrimSpace(head[to+4:])
+		case strings.HasPrefix(v, "let "):
+			eq := strings.Index(v, "=")
+			if eq == -1 || !isName(strings.TrimSpace(v[4:eq])) {
+				return nil, "", newError(parseError, "%s : expected 'let name = value'", where)
+			}
+			st.kind, st.name, st.expr = stLet, strings.TrimSpace(v[4:eq]), strings.TrimSpace(v[eq+1:])
+		case isProcCall(v):
+			i := strings.Index(v, "(")
+			st.kind, st.name = stCallProc, v[:i]
+			if args := strings.TrimSpace(v[i+1 : len(v)-1]); args != "" {
+				st.args = splitArgs(args)
+			}
+		default:
+			body = append(body, st)
+			continue
+		}
+		if st.kind == stLet || st.kind == stCallProc {
+			body = append(body, st)
+			continue
+		}
+		var closer string
+		var err error
+		st.body, closer, err = parseBlock(sim, lines, k, false)
+		if err != nil {
+			return nil, "", err
+		}
+		//	else and else if blocks belong to the if before them
+		last := &st
+		for st.kind == stIf && strings.HasPrefix(closer, "} else") {
+			rest := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(closer, "} else"), "{"))
+			if !strings.HasSuffix(closer, "{") || rest != "" && !strings.HasPrefix(rest, "if ") {
+				return nil, "", newError(parseError, "%s : expected '} else {' or '} else if condition {'", sim.where(*k-1))
+			}
+			next := statement{kind: stIf, line: *k - 1, text: closer, expr: strings.TrimSpace(strings.TrimPrefix(rest, "if "))}
+			next.body, closer, err = parseBlock(sim, lines, k, false)
+			if err != nil {
+				return nil, "", err
+			}
+			if rest == "" {
+				last.orElse = next.body
+				break
+			}
+			last.orElse = []statement{next}
+			last = &last.orElse[0]
+		}
+		if closer != "}" {
+			return nil, "", newError(parseError, "%s : block not closed with '}'", where)
+		}
+		body = append(body, st)
+	}
+	return body, "", nil
+}
+
+//	splitArgs splits the arguments of a procedure call at the commas that are
+//	not inside parentheses
+func splitArgs(s string) []string {
+	var args []string
+	depth, start := 0, 0
+	for k, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:k]))
+				start = k + 1
+			}
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+//	runScript parses and runs a script. If clk is not nil, the chip is
+//	clocked and "step" runs it a cycle at a time.
+func runScript(n *netlist, sim simulation, clk *clock, out io.Writer) error {
+	body, err := parseScript(sim)
+	if err != nil {
+		return err
+	}
+	in := &interpreter{n: n, sim: sim, out: out, procs: map[string]procedure{}, frames: []map[string]int64{{}}, clk: clk}
+	var rest []statement
+	for _, st := range body {
+		if st.kind == stProc {
+			in.procs[st.name] = procedure{st.params, st.body}
+		} else {
+			rest = append(rest, st)
+		}
+	}
+	return in.block(rest)
+}
+
+//	block runs a list of statements
+func (in *interpreter) block(body []statement) error {
+	for _, st := range body {
+		if err := in.run(st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//	run runs a single statement
+func (in *interpreter) run(st statement) error {
+	where := in.sim.where(st.line)
+	wrap := func(err error) error {
+		if err == nil || strings.HasPrefix(err.Error(), in.sim.file+":") {
+			return err
+		}
+		return fmt.Errorf("%s : %w", where, err)
+	}
+	switch st.kind {
+	case stLet:
+		v, err := in.eval(st.expr)
+		if err != nil {
+			return wrap(err)
+		}
+		in.frames[len(in.frames)-1][st.name] = v
+	case stIf:
+		v, err := in.eval(st.expr)
+		if err != nil {
+			return wrap(err)
+		}
+		if v != 0 {
+			return in.block(st.body)
+		}
+		return in.block(st.orElse)
+	case stFor:
+		from, err := in.eval(st.expr)
+		if err != nil {
+			return wrap(err)
+		}
+		to, err := in.eval(st.end)
+		if err != nil {
+			return wrap(err)
+		}
+		if to-from >= int64(loopLimit) {
+			return wrap(fmt.Errorf("loop would run more than %d times", loopLimit))
+		}
+		for i := from; i <= to; i++ {
+			in.frames[len(in.frames)-1][st.name] = i
+			if err := in.block(st.body); err != nil {
+				return err
+			}
+		}
+	case stRepeat:
+		count, err := in.eval(st.expr)
+		if err != nil {
+			return wrap(err)
+		}
+		if count > int64(loopLimit) {
+			return wrap(fmt.Errorf("loop would run more than %d times", loopLimit))
+		}
+		for i := int64(0); i < count; i++ {
+			if err := in.block(st.body); err != nil {
+				return err
+			}
+		}
+	case stWhile:
+		for k := 0; ; k++ {
+			v, err := in.eval(st.expr)
+			if err != nil {
+				return wrap(err)
+			}
+			if v == 0 {
+				break
+			}
+			if k == loopLimit {
+				return wrap(fmt.Errorf("loop ran more than %d times", loopLimit))
+			}
+			if err := in.block(st.body); err != nil {
+				return err
+			}
+		}
+	case stCallProc:
+		p, ok := in.procs[st.name]
+		if !ok {
+			return wrap(fmt.Errorf("unknown procedure '%s'", st.name))
+		}
+		if len(st.args) != len(p.params) {
+			return wrap(fmt.Errorf("'%s' takes %d arguments, %d given", st.name, len(p.params), len(st.args)))
+		}
+		if in.depth == callLimit {
+			return wrap(fmt.Errorf("procedures nested more than %d deep", callLimit))
+		}
+		frame := map[string]int64{}
+		for k, a := range st.args {
+			v, err := in.eval(a)
+			if err != nil {
+				return wrap(err)
+			}
+			frame[p.params[k]] = v
+		}
+		in.frames = append(in.frames, frame)
+		in.depth++
+		err := in.block(p.body)
+		in.depth--
+		in.frames = in.frames[:len(in.frames)-1]
+		return err
+	default:
+		return wrap(in.simple(st.text, where))
+	}
+	return nil
+}
+
+//	simple runs a line that could also appear in a script without any
+//	control statements
+func (in *interpreter) simple(v, where string) error {
+	n := in.n
+	switch {
+	case isProbe(v):
+		return n.addProbes(probeNames(v))
+	case isCheck(v):
+		c, err := parseCheck(v, where)
+		if err != nil {
+			return err
+		}
+		if c.value, err = in.value(c.value); err != nil {
+			return err
+		}
+		return n.verify(c)
+	case isVectors(v):
+		if in.clk != nil {
+			return errors.New("test vectors can only be used with 't' blocks in a clocked script")
+		}
+		return n.runVectors(hdl.RelativeTo(in.sim.file, vectorFile(v)), in.out)
+	case isTruthTable(v):
+		return n.truthTable(strings.HasSuffix(v, " x"), in.out)
+	case isRadixLine(v):
+		radix := strings.TrimSpace(v[len("radix"):])
+		if !isRadix(radix) {
+			return newError(parseError, "unknown radix '%s'", radix)
+		}
+		n.radix = radix
+	case v == "call":
+		if in.clk != nil {
+			return errors.New("CLOCKED chip not compatible with \"call\" command, use 'step'")
+		}
+		n.call(in.out)
+	case v == "step" || strings.HasPrefix(v, "step "):
+		if in.clk == nil {
+			return errors.New("only CLOCKED chips can be stepped, use 'call'")
+		}
+		count := int64(1)
+		if v != "step" {
+			var err error
+			if count, err = in.eval(v[5:]); err != nil {
+				return err
+			}
+		}
+		for k := int64(0); k < count; k++ {
+			outs, err := in.clk.tick()
+			if err != nil {
+				return err
+			}
+			n.sample()
+			if n.printing() {
+				fmt.Fprintln(in.out, n.cycleLine(in.cycle, in.clk, outs))
+			}
+			in.cycle++
+			n.reportHazards(in.out)
+		}
+	case strings.Contains(v, "="):
+		name := strings.TrimSpace(v[:strings.Index(v, "=")])
+		value := strings.TrimSpace(v[strings.Index(v, "=")+1:])
+		if frame := in.frame(name); frame != nil {
+			x, err := in.eval(value)
+			if err != nil {
+				return err
+			}
+			frame[name] = x
+			return nil
+		}
+		value, err := in.value(value)
+		if err != nil {
+			return err
+		}
+		a, err := parseAssignment(name + " = " + value)
+		if err != nil {
+			return err
+		}
+		return n.apply([]assignment{a})
+	default:
+		return newError(parseError, "unknown statement '%s'", v)
+	}
+	return nil
+}
+
+//	frame returns the variables holding the variable called name, or nil if
+//	there is no such variable
+func (in *interpreter) frame(name string) map[string]int64 {
+	if _, ok := in.frames[len(in.frames)-1][name]; ok {
+		return in.frames[len(in.frames)-1]
+	}
+	if _, ok := in.frames[0][name]; ok {
+		return in.frames[0]
+	}
+	return nil
+}
+
+//	value turns the value given to an input or expected of a wire into one
+//	that can be read as a number: literals are kept as they are, expressions
+//	are worked out
+func (in *interpreter) value(text string) (string, error) {
+	if isLiteral(text) && in.frame(text) == nil {
+		return text, nil
+	}
+	v, err := in.eval(text)
+	if err != nil {
+		return "", err
+	}
+	if v < 0 {
+		return "", fmt.Errorf("'%s' is negative", text)
+	}
+	return strconv.FormatInt(v, 10), nil
+}
+
+//	isLiteral reports whether text is a single value, rather than an expression
+func isLiteral(text string) bool {
+	if text == "X" || text == "x" || strings.HasPrefix(text, "[") {
+		return true
+	}
+	return text != "" && text[0] >= '0' && text[0] <= '9' && !strings.ContainsAny(text, " \t+-*/%()<>=!&|")
+}
+
+//	eval works out the value of an expression
+func (in *interpreter) eval(text string) (int64, error) {
+	p := &exprParser{in: in, tokens: tokenizeExpr(text)}
+	v, err := p.or()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos != len(p.tokens) {
+		return 0, newError(parseError, "unexpected '%s' in '%s'", p.tokens[p.pos], text)
+	}
+	return v, nil
+}
+
+//	tokenizeExpr splits an expression into numbers, names and operators
+func tokenizeExpr(text string) []string {
+	var tokens []string
+	for k := 0; k < len(text); {
+		c := text[k]
+		switch {
+		case c == ' ' || c == '\t':
+			k++
+		case k+1 < len(text) && strings.Contains("== != <= >= && ||", text[k:k+2]):
+			tokens = append(tokens, text[k:k+2])
+			k += 2
+		case strings.IndexByte("+-*/%()<>!,", c) != -1:
+			tokens = append(tokens, string(c))
+			k++
+		default:
+			j := k
+			for j < len(text) && strings.IndexByte(" \t+-*/%()<>=!&|,", text[j]) == -1 {
+				j++
+			}
+			if j == k {
+				//	a lone '=', '&' or '|'
+				j++
+			}
+			tokens = append(tokens, text[k:j])
+			k = j
+		}
+	}
+	return tokens
+}
+
+//	exprParser evaluates an expression as it parses it
+type exprParser struct {
+	in     *interpreter
+	tokens []string
+	pos    int
+}
+
+//	accept moves past the next token if it is one of ops, and returns it
+func (p *exprParser) accept(ops ...string) string {
+	if p.pos < len(p.tokens) {
+		for _, op := range ops {
+			if p.tokens[p.pos] == op {
+				p.pos++
+				return op
+			}
+		}
+	}
+	return ""
+}
+
+func (p *exprParser) or() (int64, error) {
+	v, err := p.and()
+	for err == nil && p.accept("||") != "" {
+		var r int64
+		if r, err = p.and(); err == nil {
+			v = truth(v != 0 || r != 0)
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) and() (int64, error) {
+	v, err := p.compare()
+	for err == nil && p.accept("&&") != "" {
+		var r int64
+		if r, err = p.compare(); err == nil {
+			v = truth(v != 0 && r != 0)
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) compare() (int64, error) {
+	v, err := p.sum()
+	for op := ""; err == nil; {
+		if op = p.accept("==", "!=", "<", "<=", ">", ">="); op == "" {
+			break
+		}
+		var r int64
+		if r, err = p.sum(); err != nil {
+			break
+		}
+		switch op {
+		case "==":
+			v = truth(v == r)
+		case "!=":
+			v = truth(v != r)
+		case "<":
+			v = truth(v < r)
+		case "<=":
+			v = truth(v <= r)
+		case ">":
+			v = truth(v > r)
+		case ">=":
+			v = truth(v >= r)
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) sum() (int64, error) {
+	v, err := p.product()
+	for op := ""; err == nil; {
+		if op = p.accept("+", "-"); op == "" {
+			break
+		}
+		var r int64
+		if r, err = p.product(); err != nil {
+			break
+		}
+		if op == "+" {
+			v += r
+		} else {
+			v -= r
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) product() (int64, error) {
+	v, err := p.unary()
+	for op := ""; err == nil; {
+		if op = p.accept("*", "/", "%"); op == "" {
+			break
+		}
+		var r int64
+		if r, err = p.unary(); err != nil {
+			break
+		}
+		switch {
+		case op == "*":
+			v *= r
+		case r == 0:
+			err = errors.New("division by zero")
+		case op == "/":
+			v /= r
+		default:
+			v %= r
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) unary() (int64, error) {
+	switch p.accept("!", "-") {
+	case "!":
+		v, err := p.unary()
+		return truth(v == 0), err
+	case "-":
+		v, err := p.unary()
+		return -v, err
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (int64, error) {
+	if p.accept("(") != "" {
+		v, err := p.or()
+		if err == nil && p.accept(")") == "" {
+			err = newError(parseError, "missing ')'")
+		}
+		return v, err
+	}
+	if p.pos == len(p.tokens) {
+		return 0, newError(parseError, "expression ends too early")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	if t[0] >= '0' && t[0] <= '9' {
+		bits, err := hdl.ParseNumber(t, 63)
+		if err != nil {
+			return 0, err
+		}
+		return bitsToInt(t, bits)
+	}
+	if frame := p.in.frame(t); frame != nil {
+		return frame[t], nil
+	}
+	nets, err := p.in.n.lookup(t)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is neither a variable nor a wire", t)
+	}
+	var bits []string
+	for _, k := range nets {
+		bits = append(bits, p.in.n.Values[k])
+	}
+	return bitsToInt(t, bits)
+}
+
+//	bitsToInt reads bits, least significant first, as an unsigned number
+func bitsToInt(name string, bits []string) (int64, error) {
+	var v int64
+	for k := len(bits) - 1; k >= 0; k-- {
+		switch bits[k] {
+		case "1":
+			v = v<<1 | 1
+		case "0":
+			v <<= 1
+		default:
+			return 0, fmt.Errorf("'%s' is unknown (X)", name)
+		}
+	}
+	return v, nil
+}
+
+//	truth turns a condition into the number 1 or 0
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//	maxTableRows is the largest truth table bru is willing to print
+var maxTableRows int = 65536
+
+//	isTruthTable reports whether a script line asks for a truth table, either
+//	as "truthtable" or as "truthtable x" to include unknown inputs
+func isTruthTable(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) > 0 && fields[0] == "truthtable"
+}
+
+//	truthTable evaluates a combinational chip for every combination of its
+//	inputs and prints the results as a table, one column per input and
+//	output. If withX is set, inputs are also given the value X. The inputs
+//	are put back the way they were afterwards.
+func (n *netlist) truthTable(withX bool, out io.Writer) error {
+	if n.top.clocked {
+		return errors.New("truth tables can only be made for combinational chips")
+	}
+	values := []string{"0", "1"}
+	if withX {
+		values = append(values, "X")
+	}
+	var bits []int
+	for _, p := range n.ins {
+		nets, _ := n.lookup(p.Name)
+		bits = append(bits, nets...)
+	}
+	rows := 1
+	for range bits {
+		rows *= len(values)
+		if rows > maxTableRows {
+			return fmt.Errorf("%s has too many inputs for a truth table", n.top.name)
+		}
+	}
+	saved := make([]string, len(bits))
+	for k, b := range bits {
+		saved[k] = n.Values[b]
+	}
+
+	header := []string{}
+	for _, p := range n.ins {
+		header = append(header, p.Name)
+	}
+	header = append(header, "|")
+	for _, p := range n.outs {
+		header = append(header, p.Name)
+	}
+	header = append(header, n.probes...)
+	table := [][]string{header}
+	for r := 0; r < rows; r++ {
+		//	the last input bit changes fastest
+		v := r
+		for k := len(bits) - 1; k >= 0; k-- {
+			n.Values[bits[k]] = values[v%len(values)]
+			v /= len(values)
+		}
+		n.evaluate()
+		var row []string
+		for _, p := range n.ins {
+			row = append(row, n.formatValue(p.Name))
+		}
+		row = append(row, "|")
+		for _, p := range n.outs {
+			row = append(row, n.formatValue(p.Name))
+		}
+		for _, name := range n.probes {
+			row = append(row, n.formatValue(name))
+		}
+		table = append(table, row)
+	}
+	for k, b := range bits {
+		n.Values[b] = saved[k]
+	}
+
+	widths := make([]int, len(header))
+	for _, row := range table {
+		for k, v := range row {
+			if len(v) > widths[k] {
+				widths[k] = len(v)
+			}
+		}
+	}
+	for _, row := range table {
+		line := ""
+		for k, v := range row {
+			line += fmt.Sprintf("%-*s ", widths[k], v)
+		}
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+	return nil
+}
+
+//	runTable prints the truth table of a chip, or of the chip scheduled for
+//	simulation if name is empty
+func runTable(name string, withX bool) int {
+	var c *chip
+	if name != "" {
+		c = findChip(name)
+	} else {
+		for k := range chips {
+			if chips[k].simulate {
+				c = &chips[k]
+				break
+			}
+		}
+	}
+	if c == nil {
+		return reportError(newError(semanticError, "no chip to make a truth table for. Name one, eg. 'bru table file.hdl chip'"))
+	}
+	n, err := buildNetlist(c, chips)
+	if err == nil {
+		err = n.addProbes(probeList)
+	}
+	if err != nil {
+		return reportError(fmt.Errorf("%s: %w", c.name, classify(semanticError, err)))
+	}
+	if err := n.truthTable(withX, os.Stdout); err != nil {
+		return reportError(fmt.Errorf("%s: %w", c.name, err))
+	}
+	return exitOK
+}
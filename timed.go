@@ -0,0 +1,262 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	The timed simulator evaluates a netlist the way real hardware behaves:
+//	every gate takes some time (its delay) to react to a change at its
+//	inputs. Changes travel through the netlist as events, kept in a queue
+//	ordered by the time at which they happen. A pulse shorter than the delay
+//	of a gate still passes through it, so glitches show up in the trace.
+
+//	timeLimit is how long the timed simulator keeps going after a change of
+//	the inputs before the design is considered to be oscillating.
+var timeLimit int = 10000
+
+//	event is the change of a net to a new value at a given time
+type event struct {
+	time  int
+	seq   int // order in which events were scheduled, to break ties
+	net   int
+	value string
+}
+
+//	eventQueue is a heap of events, earliest first
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].time != q[j].time {
+		return q[i].time < q[j].time
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+//	timedSim runs a netlist with delays
+type timedSim struct {
+	n         *netlist
+	now       int        // current simulation time
+	queue     eventQueue // changes waiting to happen
+	seq       int
+	fanout    [][]int     // elements reading every net
+	projected []string    // value of every net once the queued events happen
+	known     []string    // value of every net as last seen by the simulator
+	started   bool        // whether every element has been evaluated once
+	settled   int         // time taken by the last run to settle
+	abandoned bool        // whether the last run was given up on
+	changed   map[int]int // time of the last change of every net during the last run
+	trace     io.Writer   // if not nil, every change of a net is written to it
+
+	watch   bool             // whether to look for hazards
+	history map[int][]string // values taken by the nets during the last run
+	trigger []string         // input changes that started the last run
+	hazards []hazard         // hazards found during the last run
+}
+
+//	newTimedSim prepares a netlist for timed simulation
+func newTimedSim(n *netlist, trace io.Writer) *timedSim {
+	s := &timedSim{
+		n:         n,
+		fanout:    make([][]int, len(n.Values)),
+		projected: append([]string(nil), n.Values...),
+		known:     append([]string(nil), n.Values...),
+		trace:     trace,
+	}
+	for k, e := range n.Elems {
+		for _, in := range e.Ins {
+			s.fanout[in] = append(s.fanout[in], k)
+		}
+	}
+	n.timed = s
+	return s
+}
+
+//	schedule evaluates an element and queues the changes of its outputs
+func (s *timedSim) schedule(k int) {
+	e := &s.n.Elems[k]
+	for k, v := range s.n.Compute(e) {
+		o := e.Outs[k]
+		v = s.n.Drive(o, v)
+		if s.projected[o] != v {
+			s.projected[o] = v
+			s.seq++
+			heap.Push(&s.queue, event{s.now + e.Delay, s.seq, o, v})
+		}
+	}
+}
+
+//	change records that a net has taken a new value and returns the elements
+//	that have to be evaluated because of it
+func (s *timedSim) change(net int, value string) []int {
+	s.n.Values[net] = value
+	s.known[net] = value
+	if s.trace != nil {
+		fmt.Fprintf(s.trace, "  @%d %s = %s\n", s.now, s.n.Names[net][0], value)
+	}
+	return s.fanout[net]
+}
+
+//	run picks up the changes made to the inputs since the last run and
+//	simulates until no more events are left. The nets that were still
+//	changing when the simulation gave up are set to "X" and returned.
+func (s *timedSim) run() []int {
+	start := s.now
+	last := s.now
+	dirty := map[int]bool{}
+	s.history, s.trigger, s.hazards = nil, nil, nil
+	s.settled, s.abandoned, s.changed = 0, false, map[int]int{}
+	if s.watch {
+		s.history = map[int][]string{}
+	}
+	if !s.started {
+		for k := range s.n.Elems {
+			dirty[k] = true
+		}
+		s.started = true
+	}
+	for k := range s.n.Values {
+		if s.n.Values[k] != s.known[k] {
+			s.trigger = append(s.trigger, s.n.Names[k][0]+" "+s.known[k]+"->"+s.n.Values[k])
+			s.projected[k] = s.n.Values[k]
+			for _, e := range s.change(k, s.n.Values[k]) {
+				dirty[e] = true
+			}
+			if k == s.n.Reset {
+				//	registers anywhere may have to follow the reset signal
+				for e := range s.n.Elems {
+					dirty[e] = true
+				}
+			}
+		}
+	}
+
+	deltas := 0
+	for {
+		var elems []int
+		for k := range dirty {
+			elems = append(elems, k)
+		}
+		sort.Ints(elems)
+		for _, k := range elems {
+			s.schedule(k)
+		}
+		dirty = map[int]bool{}
+		if len(s.queue) == 0 {
+			break
+		}
+		t := s.queue[0].time
+		if t == s.now {
+			deltas++
+		} else {
+			deltas = 0
+		}
+		if deltas > settleLimit {
+			return s.abandon(s.now)
+		}
+		if t > start+timeLimit {
+			return s.abandon(s.now - timeLimit/2)
+		}
+		s.now = t
+		for len(s.queue) > 0 && s.queue[0].time == t {
+			ev := heap.Pop(&s.queue).(event)
+			if s.n.Values[ev.net] == ev.value {
+				continue
+			}
+			last = t
+			s.changed[ev.net] = t
+			s.record(ev.net, ev.value)
+			for _, e := range s.change(ev.net, ev.value) {
+				dirty[e] = true
+			}
+		}
+	}
+	s.settled = last - start
+	if s.watch {
+		s.hazards = s.findHazards()
+	}
+	return nil
+}
+
+//	abandon empties the event queue, setting the nets that still had changes
+//	waiting, or that changed at or after the given time, to "X". These nets
+//	are returned. A net in an oscillating loop need not have a change waiting
+//	at the moment the simulation gives up, but it will have changed recently.
+func (s *timedSim) abandon(from int) []int {
+	s.abandoned = true
+	pending := map[int]bool{}
+	for _, ev := range s.queue {
+		pending[ev.net] = true
+	}
+	for k, t := range s.changed {
+		if t >= from {
+			pending[k] = true
+		}
+	}
+	s.queue = nil
+	var nets []int
+	for k := range pending {
+		nets = append(nets, k)
+	}
+	sort.Ints(nets)
+	for _, k := range nets {
+		s.projected[k] = "X"
+		s.change(k, "X")
+	}
+	return nets
+}
+
+//	parseDelays reads delays for the primitive gates and memories in the form
+//	"and=2,or=2,not=1"
+func parseDelays(spec string) error {
+	for _, v := range strings.Split(spec, ",") {
+		kv := strings.Split(v, "=")
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid delay '%s'", v)
+		}
+		name := strings.TrimSpace(kv[0])
+		if !hdl.IsPrimitive(name) && !hdl.IsMemory(name) {
+			return fmt.Errorf("'%s' is not a primitive gate", name)
+		}
+		d, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid delay for '%s'", name)
+		}
+		gateDelays[name] = d
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//	With --vcd, bru writes every wire of the simulated chip to a Value Change
+//	Dump file, which waveform viewers such as GTKWave can open. Every chip
+//	instance gets a scope of its own, named as in hazard reports (eg. fa0),
+//	and buses are written as vectors. The wires are sampled once per cycle of
+//	a clocked chip, or once per 'call' of a combinational one.
+
+//	vcdFile is the file given with --vcd
+var vcdFile string
+
+//	vcdVar is a wire written to a VCD file
+type vcdVar struct {
+	name string
+	id   string
+	nets []int // bit 0 first
+	bus  bool
+	last string // value last written
+}
+
+//	vcdScope is a chip instance, holding its wires and the instances in it
+type vcdScope struct {
+	name   string
+	vars   []*vcdVar
+	scopes map[string]*vcdScope
+}
+
+//	vcdWriter collects the changes of the wires of a netlist
+type vcdWriter struct {
+	n    *netlist
+	top  *vcdScope
+	vars []*vcdVar
+	time int
+	body strings.Builder
+}
+
+//	newVCD prepares to write the wires of a netlist to a VCD file
+func newVCD(n *netlist) *vcdWriter {
+	w := &vcdWriter{n: n, top: &vcdScope{name: n.top.name, scopes: map[string]*vcdScope{}}}
+	//	bits of buses are gathered by the name of the bus
+	buses := map[string]map[int]int{}
+	var names []string
+	for name := range n.Index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		base, bit := name, -1
+		if i := strings.LastIndex(name, "["); i != -1 && strings.HasSuffix(name, "]") {
+			if k, err := strconv.Atoi(name[i+1 : len(name)-1]); err == nil {
+				base, bit = name[:i], k
+			}
+		}
+		if bit == -1 {
+			w.add(name, []int{n.Index[name]}, false)
+			continue
+		}
+		if buses[base] == nil {
+			buses[base] = map[int]int{}
+		}
+		buses[base][bit] = n.Index[name]
+	}
+	var bases []string
+	for base := range buses {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	for _, base := range bases {
+		var nets []int
+		for k := 0; k < len(buses[base]); k++ {
+			id, ok := buses[base][k]
+			if !ok {
+				break
+			}
+			nets = append(nets, id)
+		}
+		if len(nets) == len(buses[base]) {
+			w.add(base, nets, true)
+			continue
+		}
+		//	a bus with bits missing is written a bit at a time
+		for k, id := range buses[base] {
+			w.add(base+"["+strconv.Itoa(k)+"]", []int{id}, false)
+		}
+	}
+	n.vcd = w
+	return w
+}
+
+//	add places a wire in the scope of the chip instance it belongs to
+func (w *vcdWriter) add(name string, nets []int, bus bool) {
+	sc := w.top
+	parts := strings.Split(name, ".")
+	for _, p := range parts[:len(parts)-1] {
+		if sc.scopes[p] == nil {
+			sc.scopes[p] = &vcdScope{name: p, scopes: map[string]*vcdScope{}}
+		}
+		sc = sc.scopes[p]
+	}
+	v := &vcdVar{name: parts[len(parts)-1], id: vcdID(len(w.vars)), nets: nets, bus: bus}
+	sc.vars = append(sc.vars, v)
+	w.vars = append(w.vars, v)
+}
+
+//	vcdID returns the short code a VCD file uses for the k'th wire
+func vcdID(k int) string {
+	id := ""
+	for {
+		id += string(rune('!' + k%94))
+		k /= 94
+		if k == 0 {
+			return id
+		}
+	}
+}
+
+//	value returns the value of a wire the way VCD files write it
+func (w *vcdWriter) value(v *vcdVar) string {
+	bits := ""
+	for k := len(v.nets) - 1; k >= 0; k-- {
+		bits += strings.ToLower(w.n.Values[v.nets[k]])
+	}
+	if v.bus {
+		return "b" + bits + " " + v.id
+	}
+	return bits + v.id
+}
+
+//	sample writes the wires that changed since the last sample
+func (w *vcdWriter) sample() {
+	changes := ""
+	for _, v := range w.vars {
+		if val := w.value(v); val != v.last {
+			changes += val + "\n"
+			v.last = val
+		}
+	}
+	if w.time == 0 {
+		changes = "$dumpvars\n" + changes + "$end\n"
+	}
+	if changes != "" {
+		fmt.Fprintf(&w.body, "#%d\n%s", w.time, changes)
+	}
+	w.time++
+}
+
+//	String returns the whole VCD file
+func (w *vcdWriter) String() string {
+	var b strings.Builder
+	b.WriteString("$version bru $end\n$timescale 1ns $end\n")
+	w.top.write(&b)
+	b.WriteString("$enddefinitions $end\n")
+	b.WriteString(w.body.String())
+	fmt.Fprintf(&b, "#%d\n", w.time)
+	return b.String()
+}
+
+//	write writes the definitions of a scope and the scopes in it
+func (sc *vcdScope) write(b *strings.Builder) {
+	fmt.Fprintf(b, "$scope module %s $end\n", sc.name)
+	for _, v := range sc.vars {
+		if v.bus {
+			fmt.Fprintf(b, "$var wire %d %s %s [%d:0] $end\n", len(v.nets), v.id, v.name, len(v.nets)-1)
+		} else {
+			fmt.Fprintf(b, "$var wire 1 %s %s $end\n", v.id, v.name)
+		}
+	}
+	var names []string
+	for name := range sc.scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sc.scopes[name].write(b)
+	}
+	b.WriteString("$upscope $end\n")
+}
+
+//	sample records the values of the wires of the netlist after a cycle or a
+//	call, for the waveforms being written
+func (n *netlist) sample() {
+	if n.vcd != nil {
+		n.vcd.sample()
+	}
+	if n.wave != nil {
+		n.wave.sample()
+	}
+	n.record()
+}
+
+//	vcdName returns the file the VCD of a chip is written to. When several
+//	chips are simulated, each gets its own file, named after the chip.
+func vcdName(chip string, several bool) string {
+	if !several {
+		return vcdFile
+	}
+	ext := filepath.Ext(vcdFile)
+	return strings.TrimSuffix(vcdFile, ext) + "-" + chip + ext
+}
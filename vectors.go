@@ -0,0 +1,137 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	Test vectors are read from CSV files with a line "vectors file.csv" in a
+//	script. The first row of the file names the columns after the ports of the
+//	simulated chip (or bits of them, eg. "a[0]"). Every other row gives values
+//	for the inputs, and optionally the values the outputs are expected to
+//	have. In a combinational script every row is evaluated like a 'call'; in
+//	a clocked script row k is used as the block 't = k'. Values of buses may
+//	be given in binary ("0b1010"), hexadecimal ("0xA") or decimal ("10"). An
+//	empty cell leaves an input as it was, or an output unchecked. Lines
+//	starting with '#' are ignored.
+
+//	vector is a single row of a test vector file
+type vector struct {
+	inputs []assignment
+	checks []check
+}
+
+//	isVectors reports whether a script line reads test vectors from a file
+func isVectors(line string) bool {
+	return strings.HasPrefix(line, "vectors ")
+}
+
+//	vectorFile returns the name of the file a vectors line reads from
+func vectorFile(line string) string {
+	return strings.Trim(strings.TrimSpace(line[len("vectors"):]), "\"")
+}
+
+//	loadVectors reads test vectors for the top chip from a CSV file
+func (n *netlist) loadVectors(filename string) ([]vector, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, &bruError{fileError, err}
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, newError(parseError, "%s: no header row", filename)
+	} else if err != nil {
+		return nil, &bruError{parseError, err}
+	}
+	widths := make([]int, len(header))
+	for k, name := range header {
+		header[k] = strings.TrimSpace(name)
+		if !n.isInput(header[k]) && !n.isOutput(header[k]) {
+			return nil, fmt.Errorf("%s: column '%s' is not a port of %s", filename, header[k], n.top.name)
+		}
+		nets, err := n.lookup(header[k])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		widths[k] = len(nets)
+	}
+	var vectors []vector
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, &bruError{parseError, err}
+		}
+		line, _ := r.FieldPos(0)
+		where := filename + ":" + strconv.Itoa(line)
+		var v vector
+		for c, cell := range row {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+			bits, err := hdl.ParseNumber(cell, widths[c])
+			if err != nil {
+				return nil, newError(parseError, "%s : %v", where, err)
+			}
+			if n.isInput(header[c]) {
+				v.inputs = append(v.inputs, assignment{name: header[c], bits: bits})
+			} else {
+				v.checks = append(v.checks, check{name: header[c], value: n.format(header[c], bits), line: where})
+			}
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, nil
+}
+
+//	runVectors evaluates a combinational chip for every row of a test vector
+//	file, printing its outputs as 'call' does and checking them
+func (n *netlist) runVectors(filename string, out io.Writer) error {
+	vectors, err := n.loadVectors(filename)
+	if err != nil {
+		return err
+	}
+	for _, v := range vectors {
+		if err := n.apply(v.inputs); err != nil {
+			return err
+		}
+		n.call(out)
+		for _, c := range v.checks {
+			if err := n.verify(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+   Copyright (C) 2020 Ashwin Godbole
+
+   This file is part of Bru.
+
+   Bru is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   Bru is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with Bru. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ashvin-godbole/bru/internal/hdl"
+)
+
+//	With --wave, bru draws the inputs, outputs and probed wires of the chip
+//	as a timing diagram once the script has run, instead of printing a line
+//	of outputs per cycle (or per 'call'). Single bits are drawn as
+//
+//		___/‾‾‾‾‾‾‾\___xxxx
+//
+//	and buses as their value in hexadecimal, written where it changes. x
+//	stands for an unknown value.
+
+//	drawWaves is set by --wave
+var drawWaves bool
+
+//	waveform holds the values a wire took in every cycle
+type waveform struct {
+	name   string
+	bus    bool
+	values []string // bit values, or hexadecimal values for buses
+}
+
+//	waveWriter collects the values of the wires to be drawn
+type waveWriter struct {
+	n     *netlist
+	waves []*waveform
+}
+
+//	newWave prepares to draw the inputs, outputs and probed wires of a netlist
+func newWave(n *netlist) *waveWriter {
+	w := &waveWriter{n: n}
+	for _, ports := range [][]hdl.Port{n.ins, n.outs} {
+		for _, p := range ports {
+			w.waves = append(w.waves, &waveform{name: p.Name, bus: p.Bus})
+		}
+	}
+	n.wave = w
+	return w
+}
+
+//	sample records the value of every wire drawn
+func (w *waveWriter) sample() {
+	for len(w.waves) < len(w.n.ins)+len(w.n.outs)+len(w.n.probes) {
+		name := w.n.probes[len(w.waves)-len(w.n.ins)-len(w.n.outs)]
+		_, single := w.n.Index[strings.TrimPrefix(name, w.n.top.name+".")]
+		//	probes added late start out unknown
+		wave := &waveform{name: name, bus: !single}
+		if len(w.waves) > 0 {
+			for range w.waves[0].values {
+				wave.values = append(wave.values, "x")
+			}
+		}
+		w.waves = append(w.waves, wave)
+	}
+	for k, wave := range w.waves {
+		var bits []string
+		if k < len(w.n.ins) {
+			bits = w.n.cycleBits(wave.name)
+		} else {
+			nets, _ := w.n.lookup(wave.name)
+			for _, id := range nets {
+				bits = append(bits, w.n.Values[id])
+			}
+		}
+		v := strings.ToLower(bits[0])
+		if wave.bus {
+			v = strings.ToLower(bitsToHex(bits))
+			if strings.Trim(v, "x") == "" {
+				v = "x"
+			}
+		}
+		wave.values = append(wave.values, v)
+	}
+}
+
+//	draw writes the timing diagram
+func (w *waveWriter) draw(out io.Writer) {
+	if len(w.waves) == 0 || len(w.waves[0].values) == 0 {
+		return
+	}
+	cycles := len(w.waves[0].values)
+	cell, label := 4, 1
+	for _, wave := range w.waves {
+		if len(wave.name) > label {
+			label = len(wave.name)
+		}
+		for _, v := range wave.values {
+			if wave.bus && len(v)+2 > cell {
+				cell = len(v) + 2
+			}
+		}
+	}
+	if n := len(strconv.Itoa(cycles-1)) + 1; n > cell {
+		cell = n
+	}
+	axis := fmt.Sprintf("%-*s  ", label, "t")
+	for t := 0; t < cycles; t++ {
+		axis += fmt.Sprintf("%-*d", cell, t)
+	}
+	fmt.Fprintln(out, strings.TrimRight(axis, " "))
+	for _, wave := range w.waves {
+		line := fmt.Sprintf("%-*s  ", label, wave.name)
+		for t, v := range wave.values {
+			prev := v
+			if t > 0 {
+				prev = wave.values[t-1]
+			}
+			if wave.bus {
+				if t == 0 || v != prev {
+					line += fmt.Sprintf("|%-*s", cell-1, v)
+				} else {
+					line += strings.Repeat(" ", cell)
+				}
+				continue
+			}
+			level := map[string]string{"0": "_", "1": "‾", "x": "x"}[v]
+			switch {
+			case prev == "0" && v == "1":
+				line += "/" + strings.Repeat(level, cell-1)
+			case prev == "1" && v == "0":
+				line += "\\" + strings.Repeat(level, cell-1)
+			default:
+				line += strings.Repeat(level, cell)
+			}
+		}
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+}